@@ -7,11 +7,20 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 )
@@ -20,11 +29,287 @@ import (
 type Studio struct {
 }
 
+const (
+	// UNAUTHORIZED is the status returned when the caller is not allowed to run the requested function
+	UNAUTHORIZED = 403
+	// CONFLICT is the status returned when an update was based on a stale version of the document
+	CONFLICT = 409
+	// UNPROCESSABLE is the status returned when a document breaks a business rule, the message lists the violations
+	UNPROCESSABLE = 422
+
+	// ollivanderMSPID is the MSP ID of Sr. Olivaras' organization (org0)
+	ollivanderMSPID = "Org0MSP"
+
+	// roleAttribute is the certificate attribute that carries the caller role
+	roleAttribute = "role"
+	// roleAdmin is the role of the clients allowed to run the admin functions
+	roleAdmin = "admin"
+
+	// Document types, also used as the composite key namespace of each document
+	materialObjectType     = "Material"
+	wandObjectType         = "Wand"
+	supplierObjectType     = "Supplier"
+	materialTypeObjectType = "MaterialType"
+	recipeObjectType       = "Recipe"
+
+	materialPrivateDetailsObjectType = "MaterialPrivateDetails"
+	wandSalePrivateDetailsObjectType = "WandSalePrivateDetails"
+
+	// Private data, passed in the transient map under the transient keys
+	materialPrivateDetailsTransientKey = "material_private_details"
+	wandSalePrivateDetailsTransientKey = "wand_sale_private_details"
+	implicitCollectionPrefix           = "_implicit_org_"
+
+	// Composite key indexes
+	materialTypeIndex          = "materialType~ID"
+	materialStatusIndex        = "materialStatus~ID"
+	wandTypeIndex              = "wandType~ID"
+	wandStatusIndex            = "wandStatus~ID"
+	materialWandIndex          = "material~wand"
+	supplierWandIndex          = "supplier~wand"
+	supplierMSPIndex           = "supplierMSP~ID"
+	materialCategoryIndex      = "materialCategory~ID"
+	materialSupplierMonthIndex = "materialSupplierMonth~ID"
+	materialTypeMonthIndex     = "materialTypeMonth~ID"
+	materialMonthIndex         = "materialMonth~ID"
+
+	// monthLayout formats the creation month of materials in the month indexes
+	monthLayout = "2006-01"
+
+	// timeKeyLayout formats creation times in the month indexes. Fixed-width UTC timestamps sort like the times they format.
+	timeKeyLayout = "2006-01-02T15:04:05.000000000Z"
+
+	// maxQueryMonths is the largest creation time range, in months, queryMaterials scans month by month
+	maxQueryMonths = 120
+
+	// maxBatchSize is the largest number of materials initMaterialsBatch registers in one transaction
+	maxBatchSize = 100
+
+	// eventSchemaVersion is the version of the Event payload, bumped on incompatible changes
+	eventSchemaVersion = 1
+
+	// Event and event record types
+	eventMaterialRegistered       = "MaterialRegistered"
+	eventMaterialsBatchRegistered = "MaterialsBatchRegistered"
+	eventMaterialStatusChanged    = "MaterialStatusChanged"
+	eventMaterialConsumed         = "MaterialConsumed"
+	eventMaterialDeleted          = "MaterialDeleted"
+	eventMaterialRestored         = "MaterialRestored"
+	eventMaterialPurged           = "MaterialPurged"
+	eventMaterialUpdated          = "MaterialUpdated"
+	eventWandCreated              = "WandCreated"
+	eventWandDeleted              = "WandDeleted"
+	eventWandRestored             = "WandRestored"
+	eventWandPurged               = "WandPurged"
+	eventWandDismantled           = "WandDismantled"
+	eventWandRepaired             = "WandRepaired"
+	eventMaterialRemoved          = "MaterialRemoved"
+	eventMaterialReleased         = "MaterialReleased"
+	eventMaterialDestroyed        = "MaterialDestroyed"
+	eventWandSold                 = "WandSold"
+	eventWandTransferred          = "WandTransferred"
+	eventWandUpdated              = "WandUpdated"
+	eventSupplierRegistered       = "SupplierRegistered"
+	eventSupplierSuspended        = "SupplierSuspended"
+	eventMaterialTypeAdded        = "MaterialTypeAdded"
+	eventMaterialTypeDeprecated   = "MaterialTypeDeprecated"
+	eventRecipeSet                = "RecipeSet"
+	eventLedgerMigrated           = "LedgerMigrated"
+	eventIndexesRepaired          = "IndexesRepaired"
+
+	// Material lifecycle status
+	statusAvailable  = "available"
+	statusReserved   = "reserved"
+	statusConsumed   = "consumed"
+	statusWrittenOff = "written-off"
+	statusReturned   = "returned"
+	statusDestroyed  = "destroyed"
+
+	// Wand status
+	wandStatusInStock    = "in-stock"
+	wandStatusSold       = "sold"
+	wandStatusDismantled = "dismantled"
+
+	// Supplier status
+	supplierStatusActive    = "active"
+	supplierStatusSuspended = "suspended"
+
+	// Material type status
+	materialTypeStatusActive     = "active"
+	materialTypeStatusDeprecated = "deprecated"
+
+	// Keys used by older versions of the chaincode, kept only for migration
+	legacyTypeIndex            = "type~ID"
+	legacyMaterialIndexListKey = "materialIndexList"
+	legacyWandsIndexListKey    = "wandsIndexList"
+)
+
+// materialCategories lists the categories of the material type catalog
+var materialCategories = []string{"wood", "core", "finish"}
+
+// Fields that updateMaterial and updateWand can change, by JSON name.
+// The other fields are immutable or changed by their own functions.
+var (
+	materialMutableFields = []string{"type", "supplier", "attributes"}
+	wandMutableFields     = []string{"type", "color", "size"}
+)
+
+// materialTransitions maps each material status to the statuses it can move to
+var materialTransitions = map[string][]string{
+	statusAvailable:  {statusReserved, statusConsumed, statusWrittenOff, statusReturned},
+	statusReserved:   {statusAvailable, statusConsumed, statusWrittenOff, statusReturned},
+	statusConsumed:   {statusAvailable, statusDestroyed, statusWrittenOff}, // only through dismantleWand and repairWand
+	statusWrittenOff: {},
+	statusReturned:   {},
+	statusDestroyed:  {},
+}
+
+// accessRule lists who may call a chaincode function
+type accessRule struct {
+	mspIDs []string // MSP IDs allowed to call the function, empty means any organization
+	roles  []string // roles allowed to call the function, empty means any role
+}
+
+// accessRules maps every function handled by Invoke to the identities allowed to call it
+var accessRules = map[string]accessRule{
+	"registerSupplier":             {mspIDs: []string{ollivanderMSPID}},
+	"suspendSupplier":              {mspIDs: []string{ollivanderMSPID}},
+	"readSupplier":                 {},
+	"getAllSuppliers":              {},
+	"addMaterialType":              {mspIDs: []string{ollivanderMSPID}, roles: []string{roleAdmin}},
+	"deprecateMaterialType":        {mspIDs: []string{ollivanderMSPID}, roles: []string{roleAdmin}},
+	"readMaterialType":             {},
+	"getMaterialTypes":             {},
+	"setRecipe":                    {mspIDs: []string{ollivanderMSPID}, roles: []string{roleAdmin}},
+	"readRecipe":                   {},
+	"getAllRecipes":                {},
+	"getSchema":                    {},
+	"initMaterial":                 {},
+	"initMaterialsBatch":           {},
+	"readMaterial":                 {},
+	"getMaterialsByType":           {},
+	"getAllMaterials":              {},
+	"getNumberMaterialsByType":     {},
+	"getTotalNumberOfMaterials":    {},
+	"deleteMaterial":               {mspIDs: []string{ollivanderMSPID}},
+	"restoreMaterial":              {mspIDs: []string{ollivanderMSPID}},
+	"purgeMaterial":                {mspIDs: []string{ollivanderMSPID}, roles: []string{roleAdmin}},
+	"setMaterialStatus":            {mspIDs: []string{ollivanderMSPID}},
+	"updateMaterial":               {},
+	"getMaterialsByStatus":         {},
+	"queryMaterials":               {},
+	"richQuery":                    {},
+	"getMaterialHistory":           {},
+	"readMaterialPrivateDetails":   {},
+	"verifyMaterialPrivateDetails": {},
+	"getAllMaterialsAndIndexList":  {mspIDs: []string{ollivanderMSPID}},
+	"getMaterialIndexList":         {mspIDs: []string{ollivanderMSPID}},
+	"initWand":                     {mspIDs: []string{ollivanderMSPID}},
+	"readWand":                     {},
+	"getWandProvenance":            {},
+	"getWandHistory":               {},
+	"getWandsByType":               {},
+	"getAllWands":                  {},
+	"getNumberWandsByType":         {},
+	"getTotalNumberOfWands":        {},
+	"getInventoryReport":           {},
+	"deleteWand":                   {mspIDs: []string{ollivanderMSPID}},
+	"restoreWand":                  {mspIDs: []string{ollivanderMSPID}},
+	"purgeWand":                    {mspIDs: []string{ollivanderMSPID}, roles: []string{roleAdmin}},
+	"sellWand":                     {mspIDs: []string{ollivanderMSPID}},
+	"transferWand":                 {mspIDs: []string{ollivanderMSPID}},
+	"dismantleWand":                {mspIDs: []string{ollivanderMSPID}},
+	"repairWand":                   {mspIDs: []string{ollivanderMSPID}},
+	"updateWand":                   {mspIDs: []string{ollivanderMSPID}},
+	"getWandsByStatus":             {},
+	"getWandByMaterial":            {},
+	"getWandsBySupplier":           {},
+	"readWandSalePrivateDetails":   {},
+	"migrateLedger":                {mspIDs: []string{ollivanderMSPID}},
+	"checkIntegrity":               {mspIDs: []string{ollivanderMSPID}, roles: []string{roleAdmin}},
+	"repairIndexes":                {mspIDs: []string{ollivanderMSPID}, roles: []string{roleAdmin}},
+}
+
+// Supplier is an organization allowed to register materials, bound to the MSP ID of its organization
+type Supplier struct {
+	ObjectType     string   `json:"docType"`
+	ID             string   `json:"ID"`
+	Name           string   `json:"name"`
+	MSPID          string   `json:"mspID"`       // organization the supplier registers materials from
+	ContactHash    string   `json:"contactHash"` // SHA-256 of the contact details, kept off the ledger
+	Status         string   `json:"status"`      // active or suspended
+	Certifications []string `json:"certifications"`
+	Version        int      `json:"version"` // bumped every time the supplier is saved
+	Metadata
+}
+
+// MaterialType is an entry of the governed catalog of material types referenced by Material.Type
+type MaterialType struct {
+	ObjectType         string   `json:"docType"`
+	ID                 string   `json:"ID"`
+	Name               string   `json:"name"`               // display name
+	Category           string   `json:"category"`           // one of materialCategories
+	RequiredAttributes []string `json:"requiredAttributes"` // attributes every material of the type must have
+	Unit               string   `json:"unit"`               // unit the material is counted in
+	Status             string   `json:"status"`             // active or deprecated
+	Version            int      `json:"version"`            // bumped every time the material type is saved
+	Metadata
+}
+
+// Recipe is the bill of materials of a wand type: how many materials of each category a wand of the type is made of
+type Recipe struct {
+	ObjectType string            `json:"docType"`
+	WandType   string            `json:"wandType"` // also the ID of the recipe
+	Components []RecipeComponent `json:"components"`
+	Version    int               `json:"version"` // bumped every time the recipe is saved
+	Metadata
+}
+
+// RecipeComponent is the number of materials of a category required by a recipe
+type RecipeComponent struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+// RecipeViolation is one way the materials of a wand break the recipe of its type
+type RecipeViolation struct {
+	Reason    string   `json:"reason"` // missing, excess, unexpected or uncategorized
+	Category  string   `json:"category,omitempty"`
+	Expected  int      `json:"expected"`
+	Found     int      `json:"found"`
+	Materials []string `json:"materials,omitempty"` // materials of the category, or without a category
+}
+
 type Material struct {
-	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
-	ID         string `json:"ID"`      //the fieldtags are needed to keep case from bouncing around
-	Type       string `json:"type"`    //the fieldtags are needed to keep case from bouncing around
-	Supplier   string `json:"supplier"`
+	ObjectType string            `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID         string            `json:"ID"`      //the fieldtags are needed to keep case from bouncing around
+	Type       string            `json:"type"`    //the fieldtags are needed to keep case from bouncing around
+	Supplier   string            `json:"supplier"`
+	Attributes map[string]string `json:"attributes,omitempty"` // attributes required by the material type, and others
+	Status     string            `json:"status"`               // lifecycle status, see materialTransitions
+	ConsumedBy string            `json:"consumedBy,omitempty"` // ID of the wand that consumed the material
+
+	// PrivateDetailsCollections lists the private data collections holding the MaterialPrivateDetails
+	PrivateDetailsCollections []string `json:"privateDetailsCollections,omitempty"`
+
+	Version int `json:"version"` // bumped every time the material is saved
+	Metadata
+	Changes []Change `json:"changes,omitempty"` // corrections made by updateMaterial, oldest first
+
+	// Lineage lists the wands the material was part of before it left them, oldest first
+	Lineage []LineageRecord `json:"lineage,omitempty"`
+
+	Deleted *Tombstone `json:"deleted,omitempty"` // set while the material is deleted
+}
+
+// MaterialPrivateDetails keeps the commercial terms of a material out of the channel state.
+// It is saved in the implicit collections of the supplier organization and of Sr. Olivaras' organization.
+type MaterialPrivateDetails struct {
+	ObjectType  string `json:"docType"`
+	ID          string `json:"ID"`
+	UnitPrice   int    `json:"unitPrice"`   // price of one unit, in Knuts
+	ContractRef string `json:"contractRef"` // reference of the supply contract
+	BatchCode   string `json:"batchCode"`   // supplier internal batch code
 }
 
 type Wand struct {
@@ -34,33 +319,220 @@ type Wand struct {
 	Color      string   `json:"color"`
 	Size       int      `json:"size"`
 	Materials  []string `json:"Materials"`
+	Status     string   `json:"status"`          // in-stock until the wand is sold or dismantled
+	Owner      string   `json:"owner,omitempty"` // reference of the current owner, set once the wand is sold
+	Sale       *Sale    `json:"sale,omitempty"`
+
+	// OwnershipChain lists every owner of the wand since its sale, oldest first
+	OwnershipChain []OwnershipRecord `json:"ownershipChain,omitempty"`
+
+	Dismantling *Dismantling `json:"dismantling,omitempty"` // set when the wand is dismantled
+	Repairs     []Repair     `json:"repairs,omitempty"`     // repairs made by repairWand, oldest first
+
+	Version int `json:"version"` // bumped every time the wand is saved
+	Metadata
+	Changes []Change `json:"changes,omitempty"` // corrections made by updateWand, oldest first
+
+	Deleted *Tombstone `json:"deleted,omitempty"` // set while the wand is deleted
+}
+
+// Sale records how a wand left the shop. The price is kept in WandSalePrivateDetails.
+type Sale struct {
+	BuyerRef string `json:"buyerRef"` // reference of the buyer, not their personal data
+	Shop     string `json:"shop"`     // shop that sold the wand
+	SoldAt   string `json:"soldAt"`
+	TxID     string `json:"txID"`
+
+	// PrivateDetailsCollections lists the private data collections holding the WandSalePrivateDetails
+	PrivateDetailsCollections []string `json:"privateDetailsCollections,omitempty"`
+}
+
+// Dismantling records when a wand was dismantled and what happened to its materials
+type Dismantling struct {
+	DismantledAt string    `json:"dismantledAt"`
+	TxID         string    `json:"txID"`
+	DismantledBy *Identity `json:"dismantledBy"`
+	Released     []string  `json:"released"`  // materials returned to stock
+	Destroyed    []string  `json:"destroyed"` // materials that could not be reused
+}
+
+// Repair records the materials replaced in a wand, and why
+type Repair struct {
+	Reason       string        `json:"reason"`
+	RepairedAt   string        `json:"repairedAt"`
+	TxID         string        `json:"txID"`
+	RepairedBy   *Identity     `json:"repairedBy"`
+	Replacements []Replacement `json:"replacements"`
+}
+
+// Replacement is a material removed from a wand and the material installed in its place
+type Replacement struct {
+	Removed   string `json:"removed"`
+	Installed string `json:"installed"`
+}
+
+// LineageRecord tells that a material left a wand, and why
+type LineageRecord struct {
+	WandID    string `json:"wandID"`
+	Reason    string `json:"reason"` // dismantled or repaired
+	Status    string `json:"status"` // status of the material when it left the wand
+	TxID      string `json:"txID"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Tombstone records the deletion of a material or wand. The deleted document stays in state,
+// hidden from the indexes and keeping its ID, until it is restored or purged.
+type Tombstone struct {
+	DeletedAt string    `json:"deletedAt"`
+	DeletedBy *Identity `json:"deletedBy"`
+	Reason    string    `json:"reason,omitempty"`
+	TxID      string    `json:"txID"`
+	WandID    string    `json:"wandID,omitempty"` // wand the material was deleted with
+}
+
+// OwnershipRecord is one owner of a sold wand
+type OwnershipRecord struct {
+	Owner string `json:"owner"`
+	Since string `json:"since"`
+	TxID  string `json:"txID"`
+}
+
+// WandSalePrivateDetails keeps the sale price of a wand out of the channel state.
+// It is saved in the implicit collection of Sr. Olivaras' organization.
+type WandSalePrivateDetails struct {
+	ObjectType string `json:"docType"`
+	ID         string `json:"ID"`
+	Price      int    `json:"price"` // sale price, in Knuts
+}
+
+// Change records who changed which fields of a document, and the version it produced
+type Change struct {
+	Version   int           `json:"version"`
+	TxID      string        `json:"txID"`
+	Timestamp string        `json:"timestamp"`
+	ChangedBy *Identity     `json:"changedBy"`
+	Fields    []FieldChange `json:"fields"`
+}
+
+// FieldChange is the old and new JSON value of a changed field
+type FieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
+}
+
+// MaterialFilter is the filter of queryMaterials, published in schemas/queryMaterials.json
+type MaterialFilter struct {
+	Type        string `json:"type"`
+	Supplier    string `json:"supplier"`
+	Status      string `json:"status"`
+	CreatedFrom string `json:"createdFrom"` // inclusive
+	CreatedTo   string `json:"createdTo"`   // exclusive
+	IDPrefix    string `json:"idPrefix"`
+	Sort        string `json:"sort"`  // ID or createdAt
+	Order       string `json:"order"` // asc or desc
+}
+
+// BatchItemResult is the result of one material of initMaterialsBatch
+type BatchItemResult struct {
+	Index  int          `json:"index"`
+	ID     string       `json:"ID,omitempty"`
+	Status string       `json:"status"` // valid, invalid or registered
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a field of a JSON document argument that does not match its schema
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// jsonSchema is the subset of JSON Schema used by the files in schemas
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"` // false, or the schema of the other properties
+	Items                *jsonSchema            `json:"items"`
+	MinLength            *int                   `json:"minLength"`
+	Minimum              *float64               `json:"minimum"`
+	MinItems             *int                   `json:"minItems"`
+	UniqueItems          bool                   `json:"uniqueItems"`
+	Enum                 []string               `json:"enum"`
+	Format               string                 `json:"format"` // only date-time is checked
+}
+
+// Event is the payload of the chaincode event set by every state-changing function.
+// Fabric keeps a single event per transaction, so the event is named after the main change
+// and lists every document the transaction changed in Records.
+type Event struct {
+	Version   int           `json:"version"` // payload schema version, see eventSchemaVersion
+	Type      string        `json:"type"`    // same as the event name
+	TxID      string        `json:"txID"`
+	Timestamp string        `json:"timestamp"`
+	Records   []EventRecord `json:"records"`
+}
+
+// EventRecord is one document changed by the transaction, with its state after the change.
+// Deleted documents carry their last state.
+type EventRecord struct {
+	Type     string          `json:"type"`
+	DocType  string          `json:"docType"`
+	ID       string          `json:"ID"`
+	Document json.RawMessage `json:"document,omitempty"`
+}
+
+// Metadata tells when, in which transaction and by whom a document was created and last saved.
+// It is embedded in every document and stamped by its put helper on every write.
+type Metadata struct {
+	CreatedAt   string    `json:"createdAt,omitempty"`
+	CreatedTxID string    `json:"createdTxID,omitempty"`
+	CreatedBy   *Identity `json:"createdBy,omitempty"`
+	UpdatedAt   string    `json:"updatedAt,omitempty"`
+	UpdatedTxID string    `json:"updatedTxID,omitempty"`
+	UpdatedBy   *Identity `json:"updatedBy,omitempty"`
+}
+
+// Identity identifies the client that submitted a transaction
+type Identity struct {
+	MSPID   string `json:"mspID"`
+	Subject string `json:"subject"` // subject of the client certificate
+}
+
+// HistoryEntry is one version of a key, as returned by GetHistoryForKey
+type HistoryEntry struct {
+	TxID      string          `json:"txID"`
+	Timestamp string          `json:"timestamp"`
+	IsDelete  bool            `json:"isDelete"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+// WandProvenance is a wand together with the provenance of each of its materials
+type WandProvenance struct {
+	Wand      Wand                 `json:"wand"`
+	Materials []MaterialProvenance `json:"materials"`
+
+	// RemovedMaterials are the materials replaced by repairs, which are no longer part of the wand
+	RemovedMaterials []MaterialProvenance `json:"removedMaterials,omitempty"`
+}
+
+// MaterialProvenance tells who registered a material and which transactions created and consumed it
+type MaterialProvenance struct {
+	Material       Material  `json:"material"`
+	Supplier       string    `json:"supplier"`
+	RegisteredAt   string    `json:"registeredAt"`
+	RegisteredTxID string    `json:"registeredTxID"`
+	RegisteredBy   *Identity `json:"registeredBy,omitempty"`
+	ConsumedAt     string    `json:"consumedAt"`
+	ConsumedTxID   string    `json:"consumedTxID"`
 }
 
 // Init initializes chaincode
 // ===========================
 func (t *Studio) Init(stub shim.ChaincodeStubInterface) pb.Response {
-	// Verifica se a lista de materiais já existe no estado do mundo
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Falha ao verificar a existência da lista de materiais: %s", err))
-	}
-	if materialIndexListBytes == nil {
-		// A lista de materiais não existe, então a inicializamos
-		var emptyMaterialIndexList []string
-		emptyMaterialIndexListBytes, _ := json.Marshal(emptyMaterialIndexList)
-		stub.PutState("materialIndexList", emptyMaterialIndexListBytes)
-	}
-
-	// Verifica se a lista de varinhas já existe no estado do mundo
-	wandsIndexListBytes, err := stub.GetState("wandsIndexList")
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Falha ao verificar a existência da lista de varinhas: %s", err))
-	}
-	if wandsIndexListBytes == nil {
-		// A lista de varinhas não existe, então a inicializamos
-		var emptyWandsIndexList []string
-		emptyWandsIndexListBytes, _ := json.Marshal(emptyWandsIndexList)
-		stub.PutState("wandsIndexList", emptyWandsIndexListBytes)
+	// Ledgers created by older versions keep documents under raw IDs and indexes as JSON lists, they are migrated once
+	if _, err := migrateLegacyLedger(stub); err != nil {
+		return shim.Error(fmt.Sprintf("Falha ao migrar o ledger: %s", err))
 	}
 
 	return shim.Success(nil)
@@ -72,11 +544,56 @@ func (t *Studio) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
+	// Checks caller permission before running any function, functions without an access rule are refused
+	if err := checkAccess(stub, function); err != nil {
+		fmt.Println(err.Error())
+		return unauthorized(err.Error())
+	}
+
 	// Handle different functions
 	switch function {
+	case "registerSupplier":
+		// registers a new supplier
+		return t.registerSupplier(stub, args)
+	case "suspendSupplier":
+		// stops the given ID supplier from registering materials
+		return t.suspendSupplier(stub, args)
+	case "readSupplier":
+		// read the ID given supplier from chaincode state
+		return t.readSupplier(stub, args)
+	case "getAllSuppliers":
+		// returns all suppliers
+		return t.getAllSuppliers(stub)
+	case "addMaterialType":
+		// adds a material type to the catalog
+		return t.addMaterialType(stub, args)
+	case "deprecateMaterialType":
+		// stops the given ID material type from being used by new materials
+		return t.deprecateMaterialType(stub, args)
+	case "setRecipe":
+		// sets the recipe of a wand type
+		return t.setRecipe(stub, args)
+	case "readRecipe":
+		// read the recipe of the given wand type
+		return t.readRecipe(stub, args)
+	case "getAllRecipes":
+		// returns the recipes of every wand type
+		return t.getAllRecipes(stub)
+	case "getSchema":
+		// returns the JSON schema of the document accepted by a function
+		return t.getSchema(stub, args)
+	case "readMaterialType":
+		// read the ID given material type from the catalog
+		return t.readMaterialType(stub, args)
+	case "getMaterialTypes":
+		// returns the catalog, optionally of a single category
+		return t.getMaterialTypes(stub, args)
 	case "initMaterial":
 		//create a new material
 		return t.initMaterial(stub, args)
+	case "initMaterialsBatch":
+		// create several materials in a single transaction
+		return t.initMaterialsBatch(stub, args)
 	case "readMaterial":
 		//read a marble
 		return t.readMaterial(stub, args)
@@ -84,17 +601,47 @@ func (t *Studio) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		// read all materials of som specificy type
 		return t.getMaterialsByType(stub, args)
 	case "getAllMaterials":
-		// returns all materials at MaterialsIndexList
-		return t.getAllMaterials(stub)
+		// returns all available materials
+		return t.getAllMaterials(stub, args)
 	case "getNumberMaterialsByType":
 		// returns number of materials of given type at the world state
 		return t.getNumberMaterialsByType(stub, args)
 	case "getTotalNumberOfMaterials":
-		// returns total number of avaible materials on MaterialIndexList
+		// returns total number of avaible materials
 		return t.getTotalNumberOfMaterials(stub)
 	case "deleteMaterial":
 		//delete the given ID material
 		return t.deleteMaterial(stub, args)
+	case "restoreMaterial":
+		// restore the given ID deleted material
+		return t.restoreMaterial(stub, args)
+	case "purgeMaterial":
+		// remove the given ID deleted material from state
+		return t.purgeMaterial(stub, args)
+	case "setMaterialStatus":
+		// moves the given ID material to another lifecycle status
+		return t.setMaterialStatus(stub, args)
+	case "updateMaterial":
+		// corrects fields of the given ID material
+		return t.updateMaterial(stub, args)
+	case "getMaterialsByStatus":
+		// read all materials of some lifecycle status
+		return t.getMaterialsByStatus(stub, args)
+	case "queryMaterials":
+		// read the materials matching a filter
+		return t.queryMaterials(stub, args)
+	case "richQuery":
+		// read the materials or wands matching a Mango selector
+		return t.richQuery(stub, args)
+	case "getMaterialHistory":
+		// returns every version of the given ID material
+		return t.getMaterialHistory(stub, args)
+	case "readMaterialPrivateDetails":
+		// read the private details of the given ID material, for members of its collections
+		return t.readMaterialPrivateDetails(stub, args)
+	case "verifyMaterialPrivateDetails":
+		// checks transient private details against the hash of the given ID material ones
+		return t.verifyMaterialPrivateDetails(stub, args)
 	case "getAllMaterialsAndIndexList":
 		// returns all materials and index list
 		return t.getAllMaterialsAndIndexList(stub)
@@ -107,1002 +654,6040 @@ func (t *Studio) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	case "readWand":
 		// read the ID given wand from chaincode state
 		return t.readWand(stub, args)
+	case "getWandProvenance":
+		// read the ID given wand together with the provenance of its materials
+		return t.getWandProvenance(stub, args)
+	case "getWandHistory":
+		// returns every version of the given ID wand
+		return t.getWandHistory(stub, args)
 	case "getWandsByType":
-		// returns all Wands of given type at wandsIndexList
+		// returns all Wands of given type
 		return t.getWandsByType(stub, args)
 	case "getAllWands":
-		// returns all wands available at wandsIndexList
-		return t.getAllWands(stub)
+		// returns all wands
+		return t.getAllWands(stub, args)
 	case "getNumberWandsByType":
-		// returns number of wands of given type at wandsIndexList
+		// returns number of wands of given type
 		return t.getNumberwandsByType(stub, args)
 	case "getTotalNumberOfWands":
-		// returns total number of avaible wands on wandsIndexList
+		// returns total number of wands
 		return t.getTotalNumberOfWands(stub)
+	case "getInventoryReport":
+		// returns the number of materials and wands grouped by type, supplier, color and status
+		return t.getInventoryReport(stub)
 	case "deleteWand":
 		// delete the given ID wand
 		return t.deletewand(stub, args)
+	case "restoreWand":
+		// restore the given ID deleted wand and its materials
+		return t.restoreWand(stub, args)
+	case "purgeWand":
+		// remove the given ID deleted wand and its materials from state
+		return t.purgeWand(stub, args)
+	case "sellWand":
+		// sells the given ID wand to a buyer
+		return t.sellWand(stub, args)
+	case "dismantleWand":
+		// dismantles the given ID wand, returning its materials to stock or destroying them
+		return t.dismantleWand(stub, args)
+	case "repairWand":
+		// replaces materials of the given ID wand with new ones
+		return t.repairWand(stub, args)
+	case "transferWand":
+		// moves the given ID sold wand to a new owner
+		return t.transferWand(stub, args)
+	case "updateWand":
+		// corrects fields of the given ID wand
+		return t.updateWand(stub, args)
+	case "getWandsByStatus":
+		// returns all wands in stock or sold
+		return t.getWandsByStatus(stub, args)
+	case "getWandByMaterial":
+		// read the wand that consumed the given ID material
+		return t.getWandByMaterial(stub, args)
+	case "getWandsBySupplier":
+		// returns all wands made with materials of the given supplier
+		return t.getWandsBySupplier(stub, args)
+	case "readWandSalePrivateDetails":
+		// read the sale price of the given ID wand, for members of its collections
+		return t.readWandSalePrivateDetails(stub, args)
+	case "migrateLedger":
+		// moves documents and indexes written by older versions to the current layout
+		return t.migrateLedger(stub)
+	case "checkIntegrity":
+		// reports index entries and references that do not match the documents
+		return t.checkIntegrity(stub)
+	case "repairIndexes":
+		// rebuilds the material and wand indexes from the documents
+		return t.repairIndexes(stub)
 	default:
-		//error
-		fmt.Println("invoke did not find func: " + function)
-		return shim.Error("Received unknown function invocation")
+		// only reached by a function added to accessRules without its case above
+		return shim.Error("Function " + function + " has no handler")
 	}
 }
 
 // --------------------------------------------------------------------------------------------------------------------------------------------------------
-// Funções Material
-
-// ============================================================
-// initMaterial - create a new material, store into chaincode state
-// ============================================================
-func (t *Studio) initMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
-	// ==== Input sanitation ====
-	fmt.Println("- start init material")
+// Controle de acesso
 
-	// Checks the correct number of arguments
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting ID, Type and Supplier")
+// ===============================================
+// checkAccess - checks the caller identity against the access rule of the function.
+// Functions without a rule in accessRules are refused to every caller.
+// ===============================================
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	rule, ok := accessRules[function]
+	if !ok {
+		return fmt.Errorf("unauthorized: unknown function %s", function)
 	}
 
-	// Extracting the arguments
-	materialID := args[0]
-	materialType := args[1]
-	materialSupplier := args[2]
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("unauthorized: error getting MSP ID: %s", err)
+	}
 
-	// Checks that the material ID is not empty
-	if materialID == "" {
-		return shim.Error("Material ID cannot be empty")
+	if len(rule.mspIDs) > 0 && !contains(rule.mspIDs, mspid) {
+		return fmt.Errorf("unauthorized: organization %s cannot call %s", mspid, function)
 	}
 
-	// Checks if material with given ID already exists
-	if materialAsBytes, _ := stub.GetState(materialID); materialAsBytes != nil {
-		fmt.Println("This material already exists: " + materialID)
-		return shim.Error("This material already exists: " + materialID)
+	if len(rule.roles) > 0 {
+		allowed := false
+		for _, role := range rule.roles {
+			allowed, err = hasRole(stub, role)
+			if err != nil {
+				return fmt.Errorf("unauthorized: error checking caller role: %s", err)
+			}
+			if allowed {
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("unauthorized: caller needs one of the roles %v to call %s", rule.roles, function)
+		}
 	}
 
-	// Creates a material
-	material := &Material{
-		ObjectType: "Material",
-		ID:         materialID,
-		Type:       materialType,
-		Supplier:   materialSupplier,
+	return nil
+}
+
+// ===============================================
+// hasRole - checks if the caller has the given role, either as the "role" attribute
+// of its certificate or as an organizational unit (NodeOUs: admin, client, ...)
+// ===============================================
+func hasRole(stub shim.ChaincodeStubInterface, role string) (bool, error) {
+	value, found, err := cid.GetAttributeValue(stub, roleAttribute)
+	if err != nil {
+		return false, err
 	}
+	if found && value == role {
+		return true, nil
+	}
+	return cid.HasOUValue(stub, role)
+}
 
-	// Marshal the material to JSON
-	materialJSONasBytes, err := json.Marshal(material)
+// ===============================================
+// getCallerIdentity - returns the MSP ID and certificate subject of the caller
+// ===============================================
+func getCallerIdentity(stub shim.ChaincodeStubInterface) (*Identity, error) {
+	mspid, err := cid.GetMSPID(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
 
-	// === Save material to state ===
-	err = stub.PutState(material.ID, materialJSONasBytes)
+	cert, err := cid.GetX509Certificate(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
+	identity := &Identity{MSPID: mspid}
+	if cert != nil {
+		identity.Subject = cert.Subject.String()
+	}
+
+	return identity, nil
+}
 
-	// ==== Index the material to enable type-based range queries ====
-	// The composite key is based on indexName~type~ID.
-	// This will enable very efficient range queries based on composite keys matching indexName~type~*
-	indexName := "type~ID"
-	typeIndexKey, err := stub.CreateCompositeKey(indexName, []string{material.Type, material.ID})
+// getTxTimestamp returns the transaction timestamp as an RFC 3339 UTC time
+func getTxTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
 	if err != nil {
-		return shim.Error(err.Error())
+		return "", fmt.Errorf("failed to get transaction timestamp: %s", err)
+	}
+	return formatTimestamp(txTimestamp.Seconds, txTimestamp.Nanos), nil
+}
+
+// formatTimestamp returns the protobuf timestamp fields as an RFC 3339 UTC time
+func formatTimestamp(seconds int64, nanos int32) string {
+	return time.Unix(seconds, int64(nanos)).UTC().Format(time.RFC3339Nano)
+}
+
+// unauthorized returns the response sent when the caller is not allowed to run a function
+func unauthorized(msg string) pb.Response {
+	return pb.Response{
+		Status:  UNAUTHORIZED,
+		Message: msg,
+	}
+}
+
+// conflict returns the response sent when an update was based on a stale version of the document
+func conflict(msg string) pb.Response {
+	return pb.Response{
+		Status:  CONFLICT,
+		Message: msg,
 	}
+}
 
-	// Get the current material index list
-	indexListBytes, err := stub.GetState("materialIndexList")
+// unprocessable returns the response sent when a document breaks a business rule.
+// The message is a JSON object with the error and the list of violations.
+func unprocessable(msg string, violations interface{}) pb.Response {
+	messageJSON, err := json.Marshal(map[string]interface{}{
+		"error":      msg,
+		"violations": violations,
+	})
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error(msg)
 	}
+	return pb.Response{
+		Status:  UNPROCESSABLE,
+		Message: string(messageJSON),
+	}
+}
 
-	var indexList []string
-	if indexListBytes != nil {
-		// Unmarshal the index list
-		err = json.Unmarshal(indexListBytes, &indexList)
-		if err != nil {
-			return shim.Error(err.Error())
+// contains checks if the list has the given value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
 		}
 	}
+	return false
+}
+
+// --------------------------------------------------------------------------------------------------------------------------------------------------------
+// Funções suppliers
+
+// ============================================================
+// registerSupplier - registers a new active supplier, bound to the MSP ID of its organization
+// ============================================================
+func (t *Studio) registerSupplier(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start register supplier")
+
+	if len(args) < 4 {
+		return shim.Error("Incorrect number of arguments. Expecting ID, Name, MSP ID, Contact hash and optionally a list of Certifications")
+	}
+
+	supplierID := args[0]
+	name := args[1]
+	mspid := args[2]
+	contactHash := args[3]
+	certifications := append([]string{}, args[4:]...)
+
+	if supplierID == "" || name == "" || mspid == "" {
+		return shim.Error("Supplier ID, name and MSP ID cannot be empty")
+	}
+
+	// Only the hash of the contact details goes to the ledger
+	if hash, err := hex.DecodeString(contactHash); err != nil || len(hash) != sha256.Size {
+		return shim.Error("Contact hash must be the hex encoded SHA-256 of the contact details")
+	}
 
-	// Append the new index to the index list
-	indexList = append(indexList, typeIndexKey)
+	existingSupplier, err := getSupplier(stub, supplierID)
+	if err != nil {
+		return shim.Error("Failed to get supplier: " + err.Error())
+	} else if existingSupplier != nil {
+		return shim.Error("This supplier already exists: " + supplierID)
+	}
 
-	// Marshal the updated index list to JSON
-	updatedIndexListBytes, err := json.Marshal(indexList)
+	supplier := &Supplier{
+		ObjectType:     supplierObjectType,
+		ID:             supplierID,
+		Name:           name,
+		MSPID:          mspid,
+		ContactHash:    contactHash,
+		Status:         supplierStatusActive,
+		Certifications: certifications,
+	}
+	err = putSupplier(stub, supplier, nil, true)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	// Save the updated index list to state
-	err = stub.PutState("materialIndexList", updatedIndexListBytes)
+	supplierJSONasBytes, err := json.Marshal(supplier)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	// Save the index entry to the state.
-	// Only the key name is needed, no need to store a duplicate copy of the material.
-	// Note - passing a 'nil' value will effectively delete the key from state, therefore we pass a null character as value
-	err = stub.PutState(typeIndexKey, []byte{0x00})
+	err = setEvent(stub, eventSupplierRegistered, EventRecord{Type: eventSupplierRegistered, DocType: supplierObjectType, ID: supplier.ID, Document: supplierJSONasBytes})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	// ==== Material saved. Return success ====
-	fmt.Println("- end init material")
-	return shim.Success(updatedIndexListBytes)
+	fmt.Println("- end register supplier")
+	return shim.Success(supplierJSONasBytes)
 }
 
-// ===============================================
-// readMaterial - read the ID given material from chaincode state
-// ===============================================
-func (t *Studio) readMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var ID string
-	var err error
+// ============================================================
+// suspendSupplier - stops the given ID supplier from registering materials.
+// Materials already registered by the supplier are kept.
+// ============================================================
+func (t *Studio) suspendSupplier(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start suspend supplier")
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting ID of the material to query")
+		return shim.Error("Incorrect number of arguments. Expecting ID of the supplier to suspend")
 	}
 
-	ID = args[0]
-	valAsbytes, err := stub.GetState(ID) //get the material from chaincode state
+	supplierID := args[0]
+	supplier, err := getSupplier(stub, supplierID)
 	if err != nil {
-		return shim.Error("Failed to get state for " + ID + ": " + err.Error())
-	} else if valAsbytes == nil {
-		return shim.Error("Material does not exist: " + ID)
+		return shim.Error("Failed to get state for " + supplierID + ": " + err.Error())
+	} else if supplier == nil {
+		return shim.Error("Supplier does not exist: " + supplierID)
+	}
+	if supplier.Status == supplierStatusSuspended {
+		return shim.Error("Supplier " + supplierID + " is already suspended")
 	}
 
-	return shim.Success(valAsbytes)
-}
+	previous := *supplier
+	supplier.Status = supplierStatusSuspended
+	err = putSupplier(stub, supplier, &previous, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-// ============================================================
-// getMaterialIndexList returns the materialIndexList from world state
-// ============================================================
-func (t *Studio) getMaterialIndexList(stub shim.ChaincodeStubInterface) pb.Response {
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
+	supplierJSONasBytes, err := json.Marshal(supplier)
 	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+		return shim.Error(err.Error())
 	}
-	return shim.Success(materialIndexListBytes)
+
+	err = setEvent(stub, eventSupplierSuspended, EventRecord{Type: eventSupplierSuspended, DocType: supplierObjectType, ID: supplier.ID, Document: supplierJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end suspend supplier")
+	return shim.Success(supplierJSONasBytes)
 }
 
 // ===============================================
-// getMaterialsByType - returns all materials of given type at materialIndexList
+// readSupplier - read the ID given supplier from chaincode state
 // ===============================================
-func (t *Studio) getMaterialsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("- start query material by type")
-
+func (t *Studio) readSupplier(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting type of the material to query")
+		return shim.Error("Incorrect number of arguments. Expecting ID of the supplier to query")
 	}
 
-	typeIndex := args[0]
+	supplierID := args[0]
+	supplier, err := getSupplier(stub, supplierID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + supplierID + ": " + err.Error())
+	} else if supplier == nil {
+		return shim.Error("Supplier does not exist: " + supplierID)
+	}
 
-	// Retrieve the material index list from the world state
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
+	supplierJSON, err := json.Marshal(supplier)
 	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+		return shim.Error("Failed to marshal supplier to JSON: " + err.Error())
 	}
 
-	// Unmarshal the material index list from bytes
-	var materialIndexList []string
-	err = json.Unmarshal(materialIndexListBytes, &materialIndexList)
+	return shim.Success(supplierJSON)
+}
+
+// ===============================================
+// getAllSuppliers - returns all suppliers, active or suspended
+// ===============================================
+func (t *Studio) getAllSuppliers(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start query all suppliers")
+
+	indexKeys, err := getIndexKeys(stub, supplierMSPIndex, []string{})
 	if err != nil {
-		return shim.Error("Failed to unmarshal material index list: " + err.Error())
+		return shim.Error("Failed to get suppliers: " + err.Error())
 	}
 
-	// Process each material ID in the index list
-	var materials []Material
-	var cont int = 0
-	for _, compositeKey := range materialIndexList {
-		_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
-		if err != nil {
-			return shim.Error("Failed to split composite key: " + err.Error())
-		}
-		materialID := composite_parts[1]
-		materialBytes, err := stub.GetState(materialID)
+	suppliers := []Supplier{}
+	for _, indexKey := range indexKeys {
+		_, compositeKeyParts, err := stub.SplitCompositeKey(indexKey)
 		if err != nil {
-			return shim.Error("Failed to get material details: " + err.Error())
-		}
-		if materialBytes == nil {
-			return shim.Error("Material not found: " + materialID)
+			return shim.Error(err.Error())
 		}
-
-		// Unmarshal the material details
-		var material Material
-		err = json.Unmarshal(materialBytes, &material)
+		supplier, err := getSupplier(stub, compositeKeyParts[len(compositeKeyParts)-1])
 		if err != nil {
-			return shim.Error("Failed to unmarshal material details: " + strconv.Itoa(cont) + err.Error())
+			return shim.Error("Failed to get supplier: " + err.Error())
 		}
-
-		// Check if the material type matches the requested type
-		if material.Type == typeIndex {
-			// Append the material to the list
-			materials = append(materials, material)
+		if supplier == nil {
+			// Ignore index entries pointing to missing suppliers
+			continue
 		}
+		suppliers = append(suppliers, *supplier)
 	}
 
-	// Marshal the materials list to JSON
-	materialsJSON, err := json.Marshal(materials)
+	suppliersJSON, err := json.Marshal(suppliers)
 	if err != nil {
-		return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+		return shim.Error("Failed to marshal suppliers to JSON: " + err.Error())
 	}
 
-	fmt.Println("- end query material by type")
-	return shim.Success(materialsJSON)
+	fmt.Println("- end query all suppliers")
+	return shim.Success(suppliersJSON)
 }
 
 // ===============================================
-// getAllMaterials - returns all materials avaible at MaterialsIndexList
+// getSupplier - reads the ID given supplier from its Supplier~ID key.
+// Returns nil if it does not exist and an error if the key holds another docType.
 // ===============================================
-func (t *Studio) getAllMaterials(stub shim.ChaincodeStubInterface) pb.Response {
-	fmt.Println("- start query all materials")
-
-	// Retrieve the material index list from the world state
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
+func getSupplier(stub shim.ChaincodeStubInterface, supplierID string) (*Supplier, error) {
+	supplierKey, err := stub.CreateCompositeKey(supplierObjectType, []string{supplierID})
 	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+		return nil, err
 	}
 
-	// Unmarshal the material index list from bytes
-	var materialIndexList []string
-	err = json.Unmarshal(materialIndexListBytes, &materialIndexList)
+	supplierBytes, err := stub.GetState(supplierKey)
 	if err != nil {
-		return shim.Error("Failed to unmarshal material index list: " + err.Error())
+		return nil, fmt.Errorf("failed to get supplier %s: %s", supplierID, err)
 	}
-
-	// Process each material ID in the index list
-	var materials []Material
-	//var materials []string
-	for _, compositeKey := range materialIndexList {
-		_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
-		if err != nil {
-			return shim.Error("Failed to split composite key: " + err.Error())
-		}
-		materialID := composite_parts[1]
-		materialBytes, err := stub.GetState(materialID)
-		if err != nil {
-			return shim.Error("Failed to get material details: " + err.Error())
-		}
-		if materialBytes == nil {
-			// Ignore if materialBytes is nil
-			continue
-		}
-
-		// Unmarshal the material details
-		var material Material
-		err = json.Unmarshal(materialBytes, &material)
-		if err != nil {
-			return shim.Error("Failed to unmarshal material details: " + err.Error())
-		}
-		// Append the material to the list
-		materials = append(materials, material)
-
+	if supplierBytes == nil {
+		return nil, nil
 	}
 
-	// Marshal the materials list to JSON
-	materialsJSON, err := json.Marshal(materials)
+	var supplier Supplier
+	err = json.Unmarshal(supplierBytes, &supplier)
 	if err != nil {
-		return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+		return nil, fmt.Errorf("failed to unmarshal supplier %s: %s", supplierID, err)
+	}
+	if supplier.ObjectType != supplierObjectType {
+		return nil, fmt.Errorf("document %s is a %s, not a %s", supplierID, supplier.ObjectType, supplierObjectType)
 	}
 
-	fmt.Println("- end query all materials")
-	//return shim.Success(materialsJSON)
-	return shim.Success(materialsJSON)
+	return &supplier, nil
 }
 
 // ===============================================
-// getNumberMaterialsByType - returns number of materials of given type at materialIndexList
+// getActiveSupplier - reads the ID given supplier and checks that it can register materials
 // ===============================================
-func (t *Studio) getNumberMaterialsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("- start query material by type")
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting type of the material to query")
+func getActiveSupplier(stub shim.ChaincodeStubInterface, supplierID string) (*Supplier, error) {
+	supplier, err := getSupplier(stub, supplierID)
+	if err != nil {
+		return nil, err
+	}
+	if supplier == nil {
+		return nil, fmt.Errorf("supplier does not exist: %s", supplierID)
+	}
+	if supplier.Status != supplierStatusActive {
+		return nil, fmt.Errorf("supplier %s is %s", supplierID, supplier.Status)
 	}
 
-	typeIndex := args[0]
+	return supplier, nil
+}
 
-	// Retrieve the MaterialIndexList from the world state
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
+// ===============================================
+// putSupplier - saves the supplier under its Supplier~ID key, bumping its version, and keeps its
+// supplierMSP~ID index entry in sync. previous is the supplier as currently saved in state, nil for a new supplier.
+// created is only set by registerSupplier, when the supplier is created by the transaction.
+// ===============================================
+func putSupplier(stub shim.ChaincodeStubInterface, supplier *Supplier, previous *Supplier, created bool) error {
+	supplierKey, err := stub.CreateCompositeKey(supplierObjectType, []string{supplier.ID})
 	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+		return err
 	}
 
-	// Unmarshal the material index list from bytes
-	var materialIndexList []string
-	err = json.Unmarshal(materialIndexListBytes, &materialIndexList)
+	if err = stampMetadata(stub, &supplier.Metadata, created); err != nil {
+		return err
+	}
+	supplier.Version++
+	supplierJSONasBytes, err := json.Marshal(supplier)
 	if err != nil {
-		return shim.Error("Failed to unmarshal material index list: " + err.Error())
+		return err
+	}
+	err = stub.PutState(supplierKey, supplierJSONasBytes)
+	if err != nil {
+		return err
 	}
 
-	// Initialize the count of materials
-	numMaterials := 0
-
-	for _, compositeKey := range materialIndexList {
-		_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
+	// Old index entry is deleted before the new one is saved, see putMaterial
+	if previous != nil {
+		previousIndexKey, err := stub.CreateCompositeKey(supplierMSPIndex, []string{previous.MSPID, previous.ID})
 		if err != nil {
-			return shim.Error("Failed to split composite key: " + err.Error())
+			return err
 		}
-		materialType := composite_parts[0]
-		// Check if the material type matches the requested type
-		if materialType == typeIndex {
-			numMaterials++
+		if err = stub.DelState(previousIndexKey); err != nil {
+			return err
 		}
 	}
 
-	// Marshal the number of materials to JSON
-	numMaterialsJSON, err := json.Marshal(map[string]int{"num_materials": numMaterials})
+	indexKey, err := stub.CreateCompositeKey(supplierMSPIndex, []string{supplier.MSPID, supplier.ID})
 	if err != nil {
-		return shim.Error("Failed to marshal number of materials to JSON: " + err.Error())
+		return err
 	}
-
-	fmt.Println("- end query material by type")
-	return shim.Success(numMaterialsJSON)
+	return stub.PutState(indexKey, []byte{0x00})
 }
 
-// ===============================================
-// getTotalNumberOfMaterials- returns total number of avaible materials on MaterialIndexList
-// ===============================================
-func (t *Studio) getTotalNumberOfMaterials(stub shim.ChaincodeStubInterface) pb.Response {
-	fmt.Println("- start query number of materials")
+// --------------------------------------------------------------------------------------------------------------------------------------------------------
+// Funções tipos de material
+
+// ============================================================
+// addMaterialType - adds an active material type to the catalog
+// ============================================================
+func (t *Studio) addMaterialType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start add material type")
+
+	if len(args) < 4 {
+		return shim.Error("Incorrect number of arguments. Expecting ID, Name, Category, Unit and optionally a list of Required attributes")
+	}
+
+	materialTypeID := args[0]
+	name := args[1]
+	category := args[2]
+	unit := args[3]
+	requiredAttributes := append([]string{}, args[4:]...)
+
+	if materialTypeID == "" || name == "" || unit == "" {
+		return shim.Error("Material type ID, name and unit cannot be empty")
+	}
+	if !contains(materialCategories, category) {
+		return shim.Error(fmt.Sprintf("Unknown material category %s, expecting one of %v", category, materialCategories))
+	}
+	for i, attribute := range requiredAttributes {
+		if attribute == "" {
+			return shim.Error("Required attribute names cannot be empty")
+		}
+		if contains(requiredAttributes[:i], attribute) {
+			return shim.Error("Required attribute repeated: " + attribute)
+		}
+	}
 
-	// Retrieve the MaterialIndexList from the world state
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
+	existingMaterialType, err := getMaterialType(stub, materialTypeID)
 	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+		return shim.Error("Failed to get material type: " + err.Error())
+	} else if existingMaterialType != nil {
+		return shim.Error("This material type already exists: " + materialTypeID)
 	}
 
-	// Unmarshal the material index list from bytes
-	var materialIndexList []string
-	err = json.Unmarshal(materialIndexListBytes, &materialIndexList)
+	materialType := &MaterialType{
+		ObjectType:         materialTypeObjectType,
+		ID:                 materialTypeID,
+		Name:               name,
+		Category:           category,
+		RequiredAttributes: requiredAttributes,
+		Unit:               unit,
+		Status:             materialTypeStatusActive,
+	}
+	err = putMaterialType(stub, materialType, true)
 	if err != nil {
-		return shim.Error("Failed to unmarshal material index list: " + err.Error())
+		return shim.Error(err.Error())
 	}
 
-	// Get the total number of materials
-	numMaterials := len(materialIndexList)
+	materialTypeJSONasBytes, err := json.Marshal(materialType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	// Marshal the number of materials to JSON
-	numMaterialsJSON, err := json.Marshal(map[string]int{"num_materials": numMaterials})
+	err = setEvent(stub, eventMaterialTypeAdded, EventRecord{Type: eventMaterialTypeAdded, DocType: materialTypeObjectType, ID: materialType.ID, Document: materialTypeJSONasBytes})
 	if err != nil {
-		return shim.Error("Failed to marshal number of materials to JSON: " + err.Error())
+		return shim.Error(err.Error())
 	}
 
-	fmt.Println("- end query number of materials")
-	return shim.Success(numMaterialsJSON)
+	fmt.Println("- end add material type")
+	return shim.Success(materialTypeJSONasBytes)
 }
 
-// ==================================================
-// deleteMaterial - remove a material key/value pair from state
-// ==================================================
-func (t *Studio) deleteMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("- start delete material")
+// ============================================================
+// deprecateMaterialType - stops the given ID material type from being used by new materials.
+// Materials already registered with the type are kept.
+// ============================================================
+func (t *Studio) deprecateMaterialType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start deprecate material type")
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Material ID must be passed.")
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material type to deprecate")
 	}
 
-	materialID := args[0]
-
-	// Get the material details from the world state
-	valAsbytes, err := stub.GetState(materialID)
+	materialTypeID := args[0]
+	materialType, err := getMaterialType(stub, materialTypeID)
 	if err != nil {
-		return shim.Error("Failed to get state for " + materialID)
-	} else if valAsbytes == nil {
-		return shim.Error("Material does not exist: " + materialID)
+		return shim.Error("Failed to get state for " + materialTypeID + ": " + err.Error())
+	} else if materialType == nil {
+		return shim.Error("Material type does not exist: " + materialTypeID)
 	}
-
-	var materialToDelete Material
-	err = json.Unmarshal(valAsbytes, &materialToDelete)
-	if err != nil {
-		return shim.Error("Failed to decode JSON of: " + string(valAsbytes))
+	if materialType.Status == materialTypeStatusDeprecated {
+		return shim.Error("Material type " + materialTypeID + " is already deprecated")
 	}
 
-	// Delete the material from state
-	err = stub.DelState(materialID)
+	materialType.Status = materialTypeStatusDeprecated
+	err = putMaterialType(stub, materialType, false)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return shim.Error(err.Error())
 	}
 
-	// Delete the material index from the index list
-	indexName := "type~ID"
-	typeIDIndexKey, err := stub.CreateCompositeKey(indexName, []string{materialToDelete.Type, materialToDelete.ID})
+	materialTypeJSONasBytes, err := json.Marshal(materialType)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	indexListBytes, err := stub.GetState("materialIndexList")
+	err = setEvent(stub, eventMaterialTypeDeprecated, EventRecord{Type: eventMaterialTypeDeprecated, DocType: materialTypeObjectType, ID: materialType.ID, Document: materialTypeJSONasBytes})
 	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+		return shim.Error(err.Error())
 	}
 
-	var indexList []string
-	err = json.Unmarshal(indexListBytes, &indexList)
-	if err != nil {
-		return shim.Error("Failed to unmarshal index list: " + err.Error())
-	}
+	fmt.Println("- end deprecate material type")
+	return shim.Success(materialTypeJSONasBytes)
+}
 
-	for i, index := range indexList {
-		if index == typeIDIndexKey {
-			indexList = append(indexList[:i], indexList[i+1:]...)
-			break
-		}
+// ===============================================
+// readMaterialType - read the ID given material type from the catalog
+// ===============================================
+func (t *Studio) readMaterialType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material type to query")
 	}
 
-	updatedIndexListBytes, err := json.Marshal(indexList)
+	materialTypeID := args[0]
+	materialType, err := getMaterialType(stub, materialTypeID)
 	if err != nil {
-		return shim.Error("Failed to marshal updated index list: " + err.Error())
+		return shim.Error("Failed to get state for " + materialTypeID + ": " + err.Error())
+	} else if materialType == nil {
+		return shim.Error("Material type does not exist: " + materialTypeID)
 	}
 
-	err = stub.PutState("materialIndexList", updatedIndexListBytes)
+	materialTypeJSON, err := json.Marshal(materialType)
 	if err != nil {
-		return shim.Error("Failed to update index list: " + err.Error())
+		return shim.Error("Failed to marshal material type to JSON: " + err.Error())
 	}
 
-	fmt.Println("- end delete material")
-	return shim.Success(nil)
+	return shim.Success(materialTypeJSON)
 }
 
 // ===============================================
-// getAllMaterialsAndIndexList - returns all materials and index list
+// getMaterialTypes - returns the whole catalog, or the material types of the given category
 // ===============================================
-func (t *Studio) getAllMaterialsAndIndexList(stub shim.ChaincodeStubInterface) pb.Response {
-	fmt.Println("- start get all materials and index list")
+func (t *Studio) getMaterialTypes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query material types")
 
-	// Retrieve the MaterialIndexList from the world state
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
-	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting no arguments, or the category of the material types to query")
 	}
 
-	var materialIndexList []string
-	err = json.Unmarshal(materialIndexListBytes, &materialIndexList)
+	indexKeys, err := getIndexKeys(stub, materialCategoryIndex, args)
 	if err != nil {
-		return shim.Error("Failed to unmarshal material index list: " + err.Error())
+		return shim.Error("Failed to get material types: " + err.Error())
 	}
 
-	// Retrieve all materials
-	var allMaterials []Material
-	for _, compositeKey := range materialIndexList {
-		_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
+	materialTypes := []MaterialType{}
+	for _, indexKey := range indexKeys {
+		_, compositeKeyParts, err := stub.SplitCompositeKey(indexKey)
 		if err != nil {
-			return shim.Error("Failed to split composite key: " + err.Error())
+			return shim.Error(err.Error())
 		}
-		materialID := composite_parts[1]
-
-		materialBytes, err := stub.GetState(materialID)
+		materialType, err := getMaterialType(stub, compositeKeyParts[len(compositeKeyParts)-1])
 		if err != nil {
-			return shim.Error("Failed to get material details: " + err.Error())
+			return shim.Error("Failed to get material type: " + err.Error())
 		}
-		if materialBytes == nil {
-			// Ignore if materialBytes is nil
+		if materialType == nil {
+			// Ignore index entries pointing to missing material types
 			continue
 		}
-
-		// Unmarshal the material details
-		var material Material
-		err = json.Unmarshal(materialBytes, &material)
-		if err != nil {
-			return shim.Error("Failed to unmarshal material details: " + err.Error())
-		}
-		// Append the material to the list
-		allMaterials = append(allMaterials, material)
+		materialTypes = append(materialTypes, *materialType)
 	}
 
-	// Combine materials list and index list into a single JSON response
-	responseData := struct {
-		Materials []Material `json:"materials"`
-		IndexList []string   `json:"index_list"`
-	}{
-		Materials: allMaterials,
-		IndexList: materialIndexList,
-	}
-	responseDataJSON, err := json.Marshal(responseData)
+	materialTypesJSON, err := json.Marshal(materialTypes)
 	if err != nil {
-		return shim.Error("Failed to marshal response data to JSON: " + err.Error())
+		return shim.Error("Failed to marshal material types to JSON: " + err.Error())
 	}
 
-	fmt.Println("- end get all materials and index list")
-	return shim.Success(responseDataJSON)
+	fmt.Println("- end query material types")
+	return shim.Success(materialTypesJSON)
 }
 
-//--------------------------------------------------------------------------------------------
-// Funções wands
-
-// ============================================================
-// initWand - create a new wand, store into chaincode state
-// ============================================================
-func (t *Studio) initWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
-	// ==== Input sanitation ====
-	fmt.Println("- start init wand")
-
-	// Checks the correct number of arguments
-	if len(args) < 6 {
-		return shim.Error("Incorrect number of arguments. Expecting ID, Type, Color, Size and a list of Materials.")
+// ===============================================
+// getMaterialType - reads the ID given material type from its MaterialType~ID key.
+// Returns nil if it does not exist and an error if the key holds another docType.
+// ===============================================
+func getMaterialType(stub shim.ChaincodeStubInterface, materialTypeID string) (*MaterialType, error) {
+	materialTypeKey, err := stub.CreateCompositeKey(materialTypeObjectType, []string{materialTypeID})
+	if err != nil {
+		return nil, err
 	}
 
-	// Extracting the arguments
-	wandID := args[0]
-	wandType := args[1]
-	wandColor := args[2]
-	wandSize, err := strconv.Atoi(args[3])
+	materialTypeBytes, err := stub.GetState(materialTypeKey)
 	if err != nil {
-		return shim.Error("Size must be an integer.")
+		return nil, fmt.Errorf("failed to get material type %s: %s", materialTypeID, err)
+	}
+	if materialTypeBytes == nil {
+		return nil, nil
 	}
 
-	num_materials, err := strconv.Atoi(args[4])
-	var materials []string
+	var materialType MaterialType
+	err = json.Unmarshal(materialTypeBytes, &materialType)
 	if err != nil {
-		return shim.Error("Erro ao converter num_materials para inteiro: " + err.Error())
+		return nil, fmt.Errorf("failed to unmarshal material type %s: %s", materialTypeID, err)
 	}
-
-	// Loop que vai do 5 até 5 + num_materials
-	for i := 5; i < 5+num_materials; i++ {
-		materials = append(materials, args[i])
+	if materialType.ObjectType != materialTypeObjectType {
+		return nil, fmt.Errorf("document %s is a %s, not a %s", materialTypeID, materialType.ObjectType, materialTypeObjectType)
 	}
 
-	// Checks that the wand ID is not empty
-	if wandID == "" {
-		return shim.Error("Wand ID cannot be empty")
-	}
+	return &materialType, nil
+}
 
-	// Checks if wand with given ID already exists
-	wandAsBytes, err := stub.GetState(wandID)
+// ===============================================
+// checkMaterialAttributes - checks that the material type is in the catalog, active,
+// and that the material has every attribute the type requires
+// ===============================================
+func checkMaterialAttributes(stub shim.ChaincodeStubInterface, material *Material) error {
+	materialType, err := getMaterialType(stub, material.Type)
 	if err != nil {
-		return shim.Error("Failed to get wand: " + err.Error())
-	} else if wandAsBytes != nil {
-		fmt.Println("This wand already exists: " + wandID)
-		return shim.Error("This wand already exists: " + wandID)
+		return err
 	}
-
-	// Creates a Wand
-	wand := &Wand{
-		ObjectType: "Wand",
-		ID:         wandID,
-		Type:       wandType,
-		Color:      wandColor,
-		Size:       wandSize,
-		Materials:  materials,
+	if materialType == nil {
+		return fmt.Errorf("unknown material type: %s", material.Type)
 	}
-
-	// Convert Wand structure to JSON
-	wandJSONasBytes, err := json.Marshal(wand)
-	if err != nil {
-		return shim.Error("Error converting wand to JSON: " + err.Error())
+	if materialType.Status != materialTypeStatusActive {
+		return fmt.Errorf("material type %s is %s", material.Type, materialType.Status)
 	}
 
-	// Save the wand in the world state
-	err = stub.PutState(wandID, wandJSONasBytes)
-	if err != nil {
-		return shim.Error("Erro ao salvar a varinha no estado do world state: " + err.Error())
+	for _, attribute := range materialType.RequiredAttributes {
+		if material.Attributes[attribute] == "" {
+			return fmt.Errorf("material type %s requires the attribute %s", material.Type, attribute)
+		}
 	}
 
-	// Adds the wand ID to the wand index list
+	return nil
+}
 
-	// ==== Index the material to enable type-based range queries ====
-	// The composite key is based on indexName~type~ID.
-	// This will enable very efficient range queries based on composite keys matching indexName~type~*
-	indexName := "type~ID"
-	typeIndexKey, err := stub.CreateCompositeKey(indexName, []string{wand.Type, wand.ID})
+// ===============================================
+// putMaterialType - saves the material type under its MaterialType~ID key, bumping its version,
+// and indexes it by category. The category of a material type never changes.
+// created is only set by addMaterialType, when the material type is created by the transaction.
+// ===============================================
+func putMaterialType(stub shim.ChaincodeStubInterface, materialType *MaterialType, created bool) error {
+	materialTypeKey, err := stub.CreateCompositeKey(materialTypeObjectType, []string{materialType.ID})
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 
-	wandsIndexListBytes, err := stub.GetState("wandsIndexList")
-	if err != nil {
-		return shim.Error("Error saving wand in world state: " + err.Error())
+	if err = stampMetadata(stub, &materialType.Metadata, created); err != nil {
+		return err
 	}
-
-	var wandsIndexList []string
-	if wandsIndexListBytes != nil {
-		err = json.Unmarshal(wandsIndexListBytes, &wandsIndexList)
-		if err != nil {
-			return shim.Error("Error decoding wand index list: " + err.Error())
-		}
+	materialType.Version++
+	materialTypeJSONasBytes, err := json.Marshal(materialType)
+	if err != nil {
+		return err
 	}
-
-	// Adds the wand ID to the wand index list
-	wandsIndexList = append(wandsIndexList, typeIndexKey)
-	wandsIndexListBytes, err = json.Marshal(wandsIndexList)
+	err = stub.PutState(materialTypeKey, materialTypeJSONasBytes)
 	if err != nil {
-		return shim.Error("Error encoding wand index list: " + err.Error())
+		return err
 	}
 
-	err = stub.PutState("wandsIndexList", wandsIndexListBytes)
+	indexKey, err := stub.CreateCompositeKey(materialCategoryIndex, []string{materialType.Category, materialType.ID})
 	if err != nil {
-		return shim.Error("Error when saving the list of wand indexes in the world state: " + err.Error())
+		return err
 	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
 
-	// Deleting Materials ID from MaterialIndexList of world State
+// --------------------------------------------------------------------------------------------------------------------------------------------------------
+// Funções receitas
 
-	// Retrieve the MaterialIndexList from the world state
-	materialIndexListBytes, err := stub.GetState("materialIndexList")
-	if err != nil {
-		return shim.Error("Failed to get material index list: " + err.Error())
+// ============================================================
+// setRecipe - sets the recipe of a wand type, replacing the previous one.
+// Wands already made keep the materials they were made with.
+// ============================================================
+func (t *Studio) setRecipe(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start set recipe")
+
+	if len(args) < 3 || len(args)%2 != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Wand type followed by pairs of Category and Count")
 	}
 
-	var materialIndexList []string
-	err = json.Unmarshal(materialIndexListBytes, &materialIndexList)
-	if err != nil {
-		return shim.Error("Failed to unmarshal material index list: " + err.Error())
+	wandType := args[0]
+	if wandType == "" {
+		return shim.Error("Wand type cannot be empty")
 	}
 
-	// Loop through each material in wand.Materials
-	for _, materialID := range wand.Materials {
-		// Check if the material ID exists in the material index list
-		found := false
-		for i, compositeKey := range materialIndexList {
-			_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
-			if err != nil {
-				return shim.Error("Failed to split composite key: " + err.Error())
-			}
-			listID := composite_parts[1]
-			if listID == materialID {
-				// Delete the material ID from the material index list
-				materialIndexList = append(materialIndexList[:i], materialIndexList[i+1:]...)
-				found = true
-				break
-			}
+	var components []RecipeComponent
+	var categories []string
+	for i := 1; i < len(args); i += 2 {
+		category := args[i]
+		if !contains(materialCategories, category) {
+			return shim.Error(fmt.Sprintf("Unknown material category %s, expecting one of %v", category, materialCategories))
 		}
-		if !found {
-			// If the material ID is not found in the material index list
-			return shim.Error("Material ID not found in the material index list: " + materialID)
+		if contains(categories, category) {
+			return shim.Error("Category repeated in the recipe: " + category)
 		}
+		categories = append(categories, category)
+
+		count, err := strconv.Atoi(args[i+1])
+		if err != nil || count <= 0 {
+			return shim.Error("Count of " + category + " must be a positive integer")
+		}
+		components = append(components, RecipeComponent{Category: category, Count: count})
 	}
 
-	// Marshal the updated material index list and save it to the world state
-	updatedMaterialIndexListBytes, err := json.Marshal(materialIndexList)
+	recipe, err := getRecipe(stub, wandType)
 	if err != nil {
-		return shim.Error("Failed to marshal updated material index list: " + err.Error())
+		return shim.Error("Failed to get recipe: " + err.Error())
 	}
-
-	err = stub.PutState("materialIndexList", updatedMaterialIndexListBytes)
+	created := recipe == nil
+	if created {
+		recipe = &Recipe{ObjectType: recipeObjectType, WandType: wandType}
+	}
+	recipe.Components = components
+	err = putRecipe(stub, recipe, created)
 	if err != nil {
-		return shim.Error("Failed to update material index list: " + err.Error())
+		return shim.Error(err.Error())
 	}
 
-	// Returns a success message
-	fmt.Println("- end init wand")
-	return shim.Success(nil)
-}
-
-// ===============================================
-// readWand - read the ID given wand from chaincode state
-// ===============================================
-func (t *Studio) readWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var ID string
-	var err error
-
-	fmt.Println("Checking the number of arguments...")
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting ID of the wand to query")
+	recipeJSONasBytes, err := json.Marshal(recipe)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	ID = args[0]
-	valAsbytes, err := stub.GetState(ID) //get the wand from chaincode state
+	err = setEvent(stub, eventRecipeSet, EventRecord{Type: eventRecipeSet, DocType: recipeObjectType, ID: recipe.WandType, Document: recipeJSONasBytes})
 	if err != nil {
-		return shim.Error("Failed to get state for " + ID + ": " + err.Error())
-	} else if valAsbytes == nil {
-		return shim.Error("Wand does not exist: " + ID)
+		return shim.Error(err.Error())
 	}
 
-	return shim.Success(valAsbytes)
+	fmt.Println("- end set recipe")
+	return shim.Success(recipeJSONasBytes)
 }
 
 // ===============================================
-// getWandsByType - returns all Wands of given type at wandsIndexList
+// readRecipe - read the recipe of the given wand type
 // ===============================================
-func (t *Studio) getWandsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("- Start query wands by type")
-
+func (t *Studio) readRecipe(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting type of the wand to query")
-	}
-
-	typeIndex := args[0]
-
-	// Retrieve the wandsIndexList from the world state
-	fmt.Println("- Trying to get wandsIndexList")
-	wandsIndexListBytes, err := stub.GetState("wandsIndexList")
-	if err != nil {
-		return shim.Error("Failed to get wands index list: " + err.Error())
+		return shim.Error("Incorrect number of arguments. Expecting wand type of the recipe to query")
 	}
 
-	var wandsIndexList []string
-	err = json.Unmarshal(wandsIndexListBytes, &wandsIndexList)
+	wandType := args[0]
+	recipe, err := getRecipe(stub, wandType)
 	if err != nil {
-		return shim.Error("Failed to unmarshal wands index list: " + err.Error())
+		return shim.Error("Failed to get state for " + wandType + ": " + err.Error())
+	} else if recipe == nil {
+		return shim.Error("Recipe does not exist for wand type: " + wandType)
 	}
 
-	// Process each wand ID in the index list
-	var wands []Wand
-	for _, compositeKey := range wandsIndexList {
-		_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
-		if err != nil {
-			return shim.Error("Failed to split composite key: " + err.Error())
-		}
-		wandID := composite_parts[1]
-		wandBytes, err := stub.GetState(wandID)
-		if err != nil {
-			return shim.Error("Failed to get wand details: " + err.Error())
-		}
-		if wandBytes == nil {
-			return shim.Error("Wand not found: " + wandID)
-		}
-
-		// Unmarshal the wand details
-		var wand Wand
-		err = json.Unmarshal(wandBytes, &wand)
-		if err != nil {
-			return shim.Error("Failed to unmarshal wand details: " + err.Error())
-		}
-
-		// Check if the wand type matches the requested type
-		if wand.Type == typeIndex {
-			// Append the wand to the list
-			wands = append(wands, wand)
-		}
-	}
-	// Marshal the wands list to JSON
-	wandsJSON, err := json.Marshal(wands)
+	recipeJSON, err := json.Marshal(recipe)
 	if err != nil {
-		return shim.Error("Failed to marshal wands to JSON: " + err.Error())
+		return shim.Error("Failed to marshal recipe to JSON: " + err.Error())
 	}
 
-	fmt.Println("- end query wand by type")
-	return shim.Success(wandsJSON)
+	return shim.Success(recipeJSON)
 }
 
 // ===============================================
-// getAllWands - returns all wands available at wandsIndexList
+// getAllRecipes - returns the recipes of every wand type, read directly from their Recipe~WandType keys
 // ===============================================
-func (t *Studio) getAllWands(stub shim.ChaincodeStubInterface) pb.Response {
-	fmt.Println("- start query all available wands")
-
-	fmt.Println("- Trying to get wandsIndexList")
-	// Retrieve the wandsIndexList from the world state
-	wandsIndexListBytes, err := stub.GetState("wandsIndexList")
-	if err != nil {
-		return shim.Error("Failed to get wands index list: " + err.Error())
-	}
+func (t *Studio) getAllRecipes(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start query all recipes")
 
-	var wandsIndexList []string
-	err = json.Unmarshal(wandsIndexListBytes, &wandsIndexList)
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(recipeObjectType, []string{})
 	if err != nil {
-		return shim.Error("Failed to unmarshal wands index list: " + err.Error())
+		return shim.Error("Failed to get recipes: " + err.Error())
 	}
+	defer resultsIterator.Close()
 
-	// Process each wand ID in the index list
-	var wands []Wand
-	for _, compositeKey := range wandsIndexList {
-		_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
-		if err != nil {
-			return shim.Error("Failed to split composite key: " + err.Error())
-		}
-		wandID := composite_parts[1]
-		wandBytes, err := stub.GetState(wandID)
+	recipes := []Recipe{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error("Failed to get wand details: " + err.Error())
-		}
-		if wandBytes == nil {
-			return shim.Error("Wand not found: " + wandID)
+			return shim.Error("Failed to get recipes: " + err.Error())
 		}
 
-		// Unmarshal the wand details
-		var wand Wand
-		err = json.Unmarshal(wandBytes, &wand)
+		var recipe Recipe
+		err = json.Unmarshal(responseRange.Value, &recipe)
 		if err != nil {
-			return shim.Error("Failed to unmarshal wand details: " + err.Error())
+			return shim.Error("Failed to unmarshal recipe " + responseRange.Key + ": " + err.Error())
 		}
-		// Append the wand to the list
-		wands = append(wands, wand)
+		recipes = append(recipes, recipe)
 	}
 
-	// Marshal the wands list to JSON
-	wandsJSON, err := json.Marshal(wands)
+	recipesJSON, err := json.Marshal(recipes)
 	if err != nil {
-		return shim.Error("Failed to marshal wands to JSON: " + err.Error())
+		return shim.Error("Failed to marshal recipes to JSON: " + err.Error())
 	}
 
-	fmt.Println("- end query all available wands")
-	return shim.Success(wandsJSON)
+	fmt.Println("- end query all recipes")
+	return shim.Success(recipesJSON)
 }
 
 // ===============================================
-// getNumberWandsByType - returns number of wands of given type at wandsIndexList
+// getRecipe - reads the recipe of the given wand type from its Recipe~WandType key.
+// Returns nil if it does not exist and an error if the key holds another docType.
 // ===============================================
-func (t *Studio) getNumberwandsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("- start query number of wands by type")
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting type of the wands to query the number")
+func getRecipe(stub shim.ChaincodeStubInterface, wandType string) (*Recipe, error) {
+	recipeKey, err := stub.CreateCompositeKey(recipeObjectType, []string{wandType})
+	if err != nil {
+		return nil, err
 	}
 
-	typeIndex := args[0]
-	fmt.Println("- Trying to get wandsIndexList")
-	// Retrieve the wandsIndexList from the world state
-	wandsIndexListBytes, err := stub.GetState("wandsIndexList")
+	recipeBytes, err := stub.GetState(recipeKey)
 	if err != nil {
-		return shim.Error("Failed to get wands index list: " + err.Error())
+		return nil, fmt.Errorf("failed to get recipe %s: %s", wandType, err)
+	}
+	if recipeBytes == nil {
+		return nil, nil
 	}
 
-	var wandsIndexList []string
-	err = json.Unmarshal(wandsIndexListBytes, &wandsIndexList)
+	var recipe Recipe
+	err = json.Unmarshal(recipeBytes, &recipe)
 	if err != nil {
-		return shim.Error("Failed to unmarshal wand index list: " + err.Error())
+		return nil, fmt.Errorf("failed to unmarshal recipe %s: %s", wandType, err)
+	}
+	if recipe.ObjectType != recipeObjectType {
+		return nil, fmt.Errorf("document %s is a %s, not a %s", wandType, recipe.ObjectType, recipeObjectType)
 	}
 
-	// Process each wand ID in the index list
-	var num_wands int = 0
-	for _, compositeKey := range wandsIndexList {
-		_, composite_parts, err := stub.SplitCompositeKey(compositeKey)
+	return &recipe, nil
+}
+
+// ===============================================
+// putRecipe - saves the recipe under its Recipe~WandType key, bumping its version.
+// created is set when setRecipe saves the first recipe of the wand type.
+// ===============================================
+func putRecipe(stub shim.ChaincodeStubInterface, recipe *Recipe, created bool) error {
+	recipeKey, err := stub.CreateCompositeKey(recipeObjectType, []string{recipe.WandType})
+	if err != nil {
+		return err
+	}
+
+	if err = stampMetadata(stub, &recipe.Metadata, created); err != nil {
+		return err
+	}
+	recipe.Version++
+	recipeJSONasBytes, err := json.Marshal(recipe)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(recipeKey, recipeJSONasBytes)
+}
+
+// ===============================================
+// checkRecipe - checks the materials of a wand against the recipe of its type.
+// The category of each material comes from the material type catalog. Returns the violations found.
+// ===============================================
+func checkRecipe(stub shim.ChaincodeStubInterface, recipe *Recipe, materials []*Material) ([]RecipeViolation, error) {
+	var violations []RecipeViolation
+
+	// ==== Group the materials by category ====
+	materialsByCategory := make(map[string][]string)
+	var uncategorized []string
+	for _, material := range materials {
+		materialType, err := getMaterialType(stub, material.Type)
+		if err != nil {
+			return nil, err
+		}
+		if materialType == nil {
+			uncategorized = append(uncategorized, material.ID)
+			continue
+		}
+		materialsByCategory[materialType.Category] = append(materialsByCategory[materialType.Category], material.ID)
+	}
+	if len(uncategorized) > 0 {
+		violations = append(violations, RecipeViolation{Reason: "uncategorized", Found: len(uncategorized), Materials: uncategorized})
+	}
+
+	// ==== Compare each component of the recipe ====
+	for _, component := range recipe.Components {
+		found := materialsByCategory[component.Category]
+		if len(found) < component.Count {
+			violations = append(violations, RecipeViolation{Reason: "missing", Category: component.Category, Expected: component.Count, Found: len(found), Materials: found})
+		} else if len(found) > component.Count {
+			violations = append(violations, RecipeViolation{Reason: "excess", Category: component.Category, Expected: component.Count, Found: len(found), Materials: found})
+		}
+		delete(materialsByCategory, component.Category)
+	}
+
+	// ==== Categories the recipe does not use ====
+	var unexpected []string
+	for category := range materialsByCategory {
+		unexpected = append(unexpected, category)
+	}
+	sort.Strings(unexpected)
+	for _, category := range unexpected {
+		found := materialsByCategory[category]
+		violations = append(violations, RecipeViolation{Reason: "unexpected", Category: category, Expected: 0, Found: len(found), Materials: found})
+	}
+
+	return violations, nil
+}
+
+// ===============================================
+// checkWandRecipe - checks the materials of a wand against the recipe of the wand type.
+// Wand types without a recipe, such as those of the wands made by older versions, accept any materials.
+// ===============================================
+func checkWandRecipe(stub shim.ChaincodeStubInterface, wandType string, materials []*Material) ([]RecipeViolation, error) {
+	recipe, err := getRecipe(stub, wandType)
+	if err != nil {
+		return nil, err
+	}
+	if recipe == nil {
+		return nil, nil
+	}
+	return checkRecipe(stub, recipe, materials)
+}
+
+// --------------------------------------------------------------------------------------------------------------------------------------------------------
+// Funções Material
+
+// ============================================================
+// initMaterial - create a new material, store into chaincode state
+// ============================================================
+func (t *Studio) initMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var err error
+
+	// ==== Input sanitation ====
+	fmt.Println("- start init material")
+
+	// A single argument is the JSON document described by schemas/initMaterial.json
+	if len(args) == 1 {
+		var fieldErrors []FieldError
+		args, fieldErrors, err = initMaterialArgsFromDocument(args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(fieldErrors) > 0 {
+			return unprocessable("invalid initMaterial document", fieldErrors)
+		}
+	}
+
+	// Checks the correct number of arguments
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting ID, Type, Supplier ID and optionally a JSON object of Attributes, or a JSON document")
+	}
+
+	// Extracting the arguments
+	materialID := args[0]
+	materialType := args[1]
+	materialSupplier := args[2]
+	var materialAttributes map[string]string
+	if len(args) == 4 {
+		err = json.Unmarshal([]byte(args[3]), &materialAttributes)
+		if err != nil {
+			return shim.Error("Attributes must be a JSON object of strings: " + err.Error())
+		}
+	}
+
+	// Checks that the material ID is not empty
+	if materialID == "" {
+		return shim.Error("Material ID cannot be empty")
+	}
+
+	// Materials reference an active supplier of the caller organization, only Sr. Olivaras' organization registers for others
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Error getting MSP ID: " + err.Error())
+	}
+	supplier, err := getActiveSupplier(stub, materialSupplier)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mspid != ollivanderMSPID && supplier.MSPID != mspid {
+		return unauthorized("unauthorized: organization " + mspid + " can only register materials as one of its own suppliers")
+	}
+
+	// Checks if material with given ID already exists. IDs of deleted materials are not reused.
+	existingMaterial, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get material: " + err.Error())
+	} else if existingMaterial != nil && existingMaterial.Deleted != nil {
+		return shim.Error("This material was deleted, its ID cannot be reused: " + materialID)
+	} else if existingMaterial != nil {
+		fmt.Println("This material already exists: " + materialID)
+		return shim.Error("This material already exists: " + materialID)
+	}
+
+	// Commercial terms come in the transient map, so they stay out of the transaction
+	privateDetails, err := getMaterialPrivateDetailsFromTransient(stub, materialID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Creates a material
+	material := &Material{
+		ObjectType: materialObjectType,
+		ID:         materialID,
+		Type:       materialType,
+		Supplier:   materialSupplier,
+		Attributes: materialAttributes,
+		Status:     statusAvailable,
+	}
+
+	// The type must be an active entry of the catalog, and the material must have the attributes it requires
+	err = checkMaterialAttributes(stub, material)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// === Save the private details in the supplier and Sr. Olivaras' collections ===
+	if privateDetails != nil {
+		material.PrivateDetailsCollections = []string{implicitCollectionPrefix + mspid}
+		if mspid != ollivanderMSPID {
+			material.PrivateDetailsCollections = append(material.PrivateDetailsCollections, implicitCollectionPrefix+ollivanderMSPID)
+		}
+		for _, collection := range material.PrivateDetailsCollections {
+			err = putMaterialPrivateDetails(stub, collection, privateDetails)
+			if err != nil {
+				return shim.Error("Failed to save private details in " + collection + ": " + err.Error())
+			}
+		}
+	}
+
+	// === Save material to state and index it ===
+	err = putMaterial(stub, material, nil, true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Marshal the material to JSON
+	materialJSONasBytes, err := json.Marshal(material)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Notify listeners of the new material
+	err = setEvent(stub, eventMaterialRegistered, EventRecord{Type: eventMaterialRegistered, DocType: materialObjectType, ID: material.ID, Document: materialJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ==== Material saved. Return success ====
+	fmt.Println("- end init material")
+	return shim.Success(materialJSONasBytes)
+}
+
+// ============================================================
+// initMaterialsBatch - create several materials in a single transaction.
+// Takes a JSON array of initMaterial documents. Every material is validated before any is saved:
+// if one is invalid none is registered, and the report tells what is wrong with each of them.
+// ============================================================
+func (t *Studio) initMaterialsBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start init materials batch")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting a JSON array of materials")
+	}
+
+	var items []json.RawMessage
+	err := json.Unmarshal([]byte(args[0]), &items)
+	if err != nil {
+		return shim.Error("Materials must be a JSON array: " + err.Error())
+	}
+	if len(items) == 0 || len(items) > maxBatchSize {
+		return shim.Error(fmt.Sprintf("A batch must have between 1 and %d materials", maxBatchSize))
+	}
+
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Error getting MSP ID: " + err.Error())
+	}
+
+	// ==== Validate every material before saving any ====
+	results := make([]BatchItemResult, len(items))
+	materials := make([]*Material, len(items))
+	batchIndex := make(map[string]int)
+	invalid := 0
+	for i, item := range items {
+		results[i] = BatchItemResult{Index: i, Status: "valid"}
+
+		fieldErrors, err := validateDocument("initMaterial", string(item))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var document materialDocument
+		if len(fieldErrors) == 0 {
+			err = json.Unmarshal(item, &document)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			results[i].ID = document.ID
+			materials[i] = &Material{
+				ObjectType: materialObjectType,
+				ID:         document.ID,
+				Type:       document.Type,
+				Supplier:   document.Supplier,
+				Attributes: document.Attributes,
+				Status:     statusAvailable,
+			}
+
+			// The state is not updated until the transaction commits, so repeated IDs are found in the batch itself
+			if previous, ok := batchIndex[document.ID]; ok {
+				fieldErrors = append(fieldErrors, FieldError{Field: "ID", Error: fmt.Sprintf("repeats the material at index %d", previous)})
+			} else {
+				batchIndex[document.ID] = i
+			}
+
+			existingMaterial, err := getMaterial(stub, document.ID)
+			if err != nil {
+				return shim.Error("Failed to get material: " + err.Error())
+			} else if existingMaterial != nil && existingMaterial.Deleted != nil {
+				fieldErrors = append(fieldErrors, FieldError{Field: "ID", Error: "material was deleted, its ID cannot be reused"})
+			} else if existingMaterial != nil {
+				fieldErrors = append(fieldErrors, FieldError{Field: "ID", Error: "material already exists"})
+			}
+
+			supplier, err := getActiveSupplier(stub, document.Supplier)
+			if err != nil {
+				fieldErrors = append(fieldErrors, FieldError{Field: "supplier", Error: err.Error()})
+			} else if mspid != ollivanderMSPID && supplier.MSPID != mspid {
+				fieldErrors = append(fieldErrors, FieldError{Field: "supplier", Error: "organization " + mspid + " can only register materials as one of its own suppliers"})
+			}
+
+			err = checkMaterialAttributes(stub, materials[i])
+			if err != nil {
+				fieldErrors = append(fieldErrors, FieldError{Field: "type", Error: err.Error()})
+			}
+		}
+
+		if len(fieldErrors) > 0 {
+			results[i].Status = "invalid"
+			results[i].Errors = fieldErrors
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return unprocessable(fmt.Sprintf("%d of %d materials are invalid, none was registered", invalid, len(items)), results)
+	}
+
+	// ==== Save every material ====
+	var records []EventRecord
+	for i, material := range materials {
+		err = putMaterial(stub, material, nil, true)
+		if err != nil {
+			return shim.Error("Failed to save material " + material.ID + ": " + err.Error())
+		}
+		results[i].Status = "registered"
+
+		record, err := newEventRecord(eventMaterialRegistered, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// Notify listeners of every new material
+	err = setEvent(stub, eventMaterialsBatchRegistered, records...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return shim.Error("Failed to marshal batch results to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end init materials batch")
+	return shim.Success(resultsJSON)
+}
+
+// ===============================================
+// readMaterial - read the ID given material from chaincode state
+// ===============================================
+func (t *Studio) readMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var ID string
+	var err error
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material to query")
+	}
+
+	ID = args[0]
+	material, err := getMaterial(stub, ID) //get the material from chaincode state
+	if err != nil {
+		return shim.Error("Failed to get state for " + ID + ": " + err.Error())
+	} else if material == nil {
+		return shim.Error("Material does not exist: " + ID)
+	}
+
+	valAsbytes, err := json.Marshal(material)
+	if err != nil {
+		return shim.Error("Failed to marshal material to JSON: " + err.Error())
+	}
+
+	return shim.Success(valAsbytes)
+}
+
+// ============================================================
+// getMaterialIndexList returns the type~ID composite keys of all available materials
+// ============================================================
+func (t *Studio) getMaterialIndexList(stub shim.ChaincodeStubInterface) pb.Response {
+	materialIndexList, err := getIndexKeys(stub, materialTypeIndex, []string{})
+	if err != nil {
+		return shim.Error("Failed to get material index list: " + err.Error())
+	}
+
+	materialIndexListBytes, err := json.Marshal(materialIndexList)
+	if err != nil {
+		return shim.Error("Failed to marshal material index list: " + err.Error())
+	}
+	return shim.Success(materialIndexListBytes)
+}
+
+// ===============================================
+// getMaterialsByType - returns all available materials of given type, or one page of them
+// when the page size and bookmark are passed
+// ===============================================
+func (t *Studio) getMaterialsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query material by type")
+
+	if len(args) != 1 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting type of the material to query, optionally followed by page size and bookmark")
+	}
+
+	typeIndex := args[0]
+	if len(args) == 3 {
+		return getMaterialsPage(stub, materialTypeIndex, []string{typeIndex}, args[1:])
+	}
+
+	// Query the type~ID index by type
+	// This will execute a key range query on all keys starting with 'type'
+	materials, err := getMaterialsFromIndex(stub, materialTypeIndex, []string{typeIndex})
+	if err != nil {
+		return shim.Error("Failed to get materials: " + err.Error())
+	}
+
+	// Marshal the materials list to JSON
+	materialsJSON, err := json.Marshal(materials)
+	if err != nil {
+		return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query material by type")
+	return shim.Success(materialsJSON)
+}
+
+// ===============================================
+// getAllMaterials - returns all available materials, or one page of them
+// when the page size and bookmark are passed
+// ===============================================
+func (t *Studio) getAllMaterials(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query all materials")
+
+	if len(args) != 0 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting no arguments, or page size and bookmark")
+	}
+	if len(args) == 2 {
+		return getMaterialsPage(stub, materialTypeIndex, []string{}, args)
+	}
+
+	// Query the type~ID index with no type, matching every available material
+	materials, err := getMaterialsFromIndex(stub, materialTypeIndex, []string{})
+	if err != nil {
+		return shim.Error("Failed to get materials: " + err.Error())
+	}
+
+	// Marshal the materials list to JSON
+	materialsJSON, err := json.Marshal(materials)
+	if err != nil {
+		return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query all materials")
+	return shim.Success(materialsJSON)
+}
+
+// ===============================================
+// getNumberMaterialsByType - returns number of available materials of given type
+// ===============================================
+func (t *Studio) getNumberMaterialsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query material by type")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting type of the material to query")
+	}
+
+	typeIndex := args[0]
+
+	// Count the index entries of the given type
+	numMaterials, err := countIndexKeys(stub, materialTypeIndex, []string{typeIndex})
+	if err != nil {
+		return shim.Error("Failed to get material index: " + err.Error())
+	}
+
+	// Marshal the number of materials to JSON
+	numMaterialsJSON, err := json.Marshal(map[string]int{"num_materials": numMaterials})
+	if err != nil {
+		return shim.Error("Failed to marshal number of materials to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query material by type")
+	return shim.Success(numMaterialsJSON)
+}
+
+// ===============================================
+// getTotalNumberOfMaterials- returns total number of avaible materials
+// ===============================================
+func (t *Studio) getTotalNumberOfMaterials(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start query number of materials")
+
+	// Count every entry of the material index
+	numMaterials, err := countIndexKeys(stub, materialTypeIndex, []string{})
+	if err != nil {
+		return shim.Error("Failed to get material index: " + err.Error())
+	}
+
+	// Marshal the number of materials to JSON
+	numMaterialsJSON, err := json.Marshal(map[string]int{"num_materials": numMaterials})
+	if err != nil {
+		return shim.Error("Failed to marshal number of materials to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query number of materials")
+	return shim.Success(numMaterialsJSON)
+}
+
+// ==================================================
+// deleteMaterial - marks the given ID material as deleted with a tombstone. The material is hidden
+// from the list queries but stays in state, so its ID cannot be reused, until it is restored or purged.
+// ==================================================
+func (t *Studio) deleteMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start delete material")
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Material ID must be passed, optionally followed by the reason.")
+	}
+
+	materialID := args[0]
+	reason := ""
+	if len(args) == 2 {
+		reason = args[1]
+	}
+
+	// Get the material details from the world state
+	materialToDelete, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + materialID + ": " + err.Error())
+	} else if materialToDelete == nil {
+		return shim.Error("Material does not exist: " + materialID)
+	}
+	if materialToDelete.Deleted != nil {
+		return shim.Error("Material " + materialID + " is already deleted")
+	}
+
+	// A consumed material belongs to a wand and goes away with it
+	if materialToDelete.Status == statusConsumed {
+		return shim.Error("Material " + materialID + " is consumed by wand " + materialToDelete.ConsumedBy + " and cannot be deleted")
+	}
+
+	tombstone, err := newTombstone(stub, reason, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Save the tombstone, which removes the material index entries
+	previous := *materialToDelete
+	materialToDelete.Deleted = tombstone
+	err = putMaterial(stub, materialToDelete, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to delete material " + materialID + ": " + err.Error())
+	}
+
+	record, err := newEventRecord(eventMaterialDeleted, materialObjectType, materialToDelete.ID, materialToDelete)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setEvent(stub, eventMaterialDeleted, record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end delete material")
+	return shim.Success(nil)
+}
+
+// ==================================================
+// restoreMaterial - removes the tombstone of the given ID deleted material, which shows up again in the list queries.
+// Materials deleted with their wand are restored by restoreWand.
+// ==================================================
+func (t *Studio) restoreMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start restore material")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Material ID must be passed.")
+	}
+
+	materialID := args[0]
+	material, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + materialID + ": " + err.Error())
+	} else if material == nil {
+		return shim.Error("Material does not exist: " + materialID)
+	}
+	if material.Deleted == nil {
+		return shim.Error("Material " + materialID + " is not deleted")
+	}
+	if material.Deleted.WandID != "" {
+		return shim.Error("Material " + materialID + " was deleted with wand " + material.Deleted.WandID + ", restore the wand instead")
+	}
+
+	previous := *material
+	material.Deleted = nil
+	err = putMaterial(stub, material, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to restore material " + materialID + ": " + err.Error())
+	}
+
+	materialJSONasBytes, err := json.Marshal(material)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setEvent(stub, eventMaterialRestored, EventRecord{Type: eventMaterialRestored, DocType: materialObjectType, ID: material.ID, Document: materialJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end restore material")
+	return shim.Success(materialJSONasBytes)
+}
+
+// ==================================================
+// purgeMaterial - removes the given ID deleted material and its private details from state.
+// Its history is kept, but its ID can be reused afterwards.
+// ==================================================
+func (t *Studio) purgeMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start purge material")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Material ID must be passed.")
+	}
+
+	materialID := args[0]
+	material, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + materialID + ": " + err.Error())
+	} else if material == nil {
+		return shim.Error("Material does not exist: " + materialID)
+	}
+
+	// Only deleted materials can be purged, the ones deleted with a wand are purged with it
+	if material.Deleted == nil {
+		return shim.Error("Material " + materialID + " is not deleted")
+	}
+	if material.Deleted.WandID != "" {
+		return shim.Error("Material " + materialID + " was deleted with wand " + material.Deleted.WandID + ", purge the wand instead")
+	}
+
+	err = delMaterial(stub, material)
+	if err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+
+	record, err := newEventRecord(eventMaterialPurged, materialObjectType, material.ID, material)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setEvent(stub, eventMaterialPurged, record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end purge material")
+	return shim.Success(nil)
+}
+
+// ==================================================
+// setMaterialStatus - moves the given ID material to another lifecycle status
+// ==================================================
+func (t *Studio) setMaterialStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start set material status")
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting ID and Status of the material")
+	}
+
+	materialID := args[0]
+	status := args[1]
+
+	// Materials are only consumed by initWand, which also records the wand
+	if status == statusConsumed {
+		return shim.Error("Materials can only be consumed by initWand")
+	}
+
+	material, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + materialID + ": " + err.Error())
+	} else if material == nil {
+		return shim.Error("Material does not exist: " + materialID)
+	}
+
+	// Consumed materials only leave their wand when it is dismantled, which records the lineage
+	if material.Status == statusConsumed {
+		return shim.Error("Material " + materialID + " is consumed by wand " + material.ConsumedBy + ", it can only be released by dismantleWand")
+	}
+
+	err = checkMaterialTransition(material, status)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	previous := *material
+	material.Status = status
+	err = putMaterial(stub, material, &previous, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	materialJSONasBytes, err := json.Marshal(material)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setEvent(stub, eventMaterialStatusChanged, EventRecord{Type: eventMaterialStatusChanged, DocType: materialObjectType, ID: material.ID, Document: materialJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end set material status")
+	return shim.Success(materialJSONasBytes)
+}
+
+// ==================================================
+// updateMaterial - corrects fields of the given ID material with a partial JSON patch.
+// The update is refused when the material is no longer at the expected version.
+// ==================================================
+func (t *Studio) updateMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start update material")
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material, JSON patch and expected version")
+	}
+
+	materialID := args[0]
+	patch := args[1]
+	expectedVersion, err := strconv.Atoi(args[2])
+	if err != nil {
+		return shim.Error("Expected version must be an integer")
+	}
+
+	material, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + materialID + ": " + err.Error())
+	} else if material == nil {
+		return shim.Error("Material does not exist: " + materialID)
+	}
+
+	// Suppliers may only correct their own materials, only Sr. Olivaras' organization corrects others
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Error getting MSP ID: " + err.Error())
+	}
+	if mspid != ollivanderMSPID {
+		supplier, err := getSupplier(stub, material.Supplier)
+		if err != nil {
+			return shim.Error("Failed to get supplier: " + err.Error())
+		}
+		if supplier == nil || supplier.MSPID != mspid {
+			return unauthorized("unauthorized: organization " + mspid + " can only update materials of its own suppliers")
+		}
+	}
+
+	if material.Deleted != nil {
+		return shim.Error("Material " + materialID + " is deleted")
+	}
+	if material.Version != expectedVersion {
+		return conflict(fmt.Sprintf("stale update: material %s is at version %d, not %d", materialID, material.Version, expectedVersion))
+	}
+
+	previous := *material
+	previous.Attributes = copyAttributes(material.Attributes)
+	fields, err := applyPatch(material, patch, materialMutableFields)
+	if err != nil {
+		return shim.Error("Invalid patch for material " + materialID + ": " + err.Error())
+	}
+	if len(fields) == 0 {
+		return shim.Error("Patch does not change material " + materialID)
+	}
+	// A new type or new attributes are validated against the catalog
+	for _, field := range fields {
+		if field.Field == "type" || field.Field == "attributes" {
+			err = checkMaterialAttributes(stub, material)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			break
+		}
+	}
+
+	// A consumed material moved to another type must keep its wand following the recipe
+	if material.Type != previous.Type && material.ConsumedBy != "" {
+		wand, err := getWand(stub, material.ConsumedBy)
+		if err != nil {
+			return shim.Error("Failed to get wand " + material.ConsumedBy + ": " + err.Error())
+		} else if wand == nil {
+			return shim.Error("Wand does not exist: " + material.ConsumedBy)
+		}
+		var materials []*Material
+		for _, wandMaterialID := range wand.Materials {
+			if wandMaterialID == materialID {
+				materials = append(materials, material)
+				continue
+			}
+			wandMaterial, err := getMaterial(stub, wandMaterialID)
+			if err != nil {
+				return shim.Error("Failed to get material details: " + err.Error())
+			} else if wandMaterial == nil {
+				return shim.Error("Material not found: " + wandMaterialID)
+			}
+			materials = append(materials, wandMaterial)
+		}
+		violations, err := checkWandRecipe(stub, wand.Type, materials)
+		if err != nil {
+			return shim.Error("Failed to check recipe: " + err.Error())
+		}
+		if len(violations) > 0 {
+			return unprocessable("wand "+wand.ID+" would not follow the recipe of type "+wand.Type+" with material "+materialID+" of type "+material.Type, violations)
+		}
+	}
+
+	// A new supplier must be active and, for suppliers, belong to the caller organization
+	if material.Supplier != previous.Supplier {
+		supplier, err := getActiveSupplier(stub, material.Supplier)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if mspid != ollivanderMSPID && supplier.MSPID != mspid {
+			return unauthorized("unauthorized: organization " + mspid + " cannot move its materials to a supplier of another organization")
+		}
+	}
+
+	change, err := newChange(stub, material.Version+1, fields)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	material.Changes = append(material.Changes, change)
+
+	err = putMaterial(stub, material, &previous, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	materialJSONasBytes, err := json.Marshal(material)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setEvent(stub, eventMaterialUpdated, EventRecord{Type: eventMaterialUpdated, DocType: materialObjectType, ID: material.ID, Document: materialJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end update material")
+	return shim.Success(materialJSONasBytes)
+}
+
+// ===============================================
+// queryMaterials - returns the materials matching a JSON filter (type, supplier, status, creation time range
+// and ID prefix) in the requested order, or one page of them when the page size and bookmark are passed.
+// The filter must set a criterion an index narrows, the other criteria are checked on the materials read.
+// ===============================================
+func (t *Studio) queryMaterials(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query materials")
+
+	if len(args) != 1 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting JSON filter, optionally followed by page size and bookmark")
+	}
+
+	fieldErrors, err := validateDocument("queryMaterials", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(fieldErrors) > 0 {
+		return unprocessable("invalid queryMaterials filter", fieldErrors)
+	}
+	var filter MaterialFilter
+	if err = json.Unmarshal([]byte(args[0]), &filter); err != nil {
+		return shim.Error("Failed to decode filter: " + err.Error())
+	}
+
+	scan, fieldErrors, err := planMaterialScan(stub, &filter)
+	if err != nil {
+		return shim.Error("Failed to query materials: " + err.Error())
+	}
+	if len(fieldErrors) > 0 {
+		return unprocessable("invalid queryMaterials filter", fieldErrors)
+	}
+
+	if len(args) == 1 {
+		materials, err := queryMaterialsByFilter(stub, scan, &filter)
+		if err != nil {
+			return shim.Error("Failed to query materials: " + err.Error())
+		}
+		materialsJSON, err := json.Marshal(materials)
+		if err != nil {
+			return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+		}
+		fmt.Println("- end query materials")
+		return shim.Success(materialsJSON)
+	}
+
+	pageSize, bookmark, err := parsePagination(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Pages are read in the order of the index, which cannot be sorted otherwise. The default ID
+	// order only holds on the status index, the month indexes must ask for createdAt explicitly.
+	sortField := filter.Sort
+	if sortField == "" {
+		sortField = "ID"
+	}
+	if sortField != scan.sort {
+		fieldErrors = append(fieldErrors, FieldError{Field: "sort", Error: "paged queries on the " + scan.indexName + " index are sorted by " + scan.sort + ", set sort to " + scan.sort})
+	}
+	if filter.Order == "desc" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "order", Error: "paged queries are sorted in ascending order"})
+	}
+	if len(fieldErrors) > 0 {
+		return unprocessable("invalid queryMaterials filter", fieldErrors)
+	}
+
+	page, err := queryMaterialsPage(stub, scan, &filter, pageSize, bookmark)
+	if err != nil {
+		return shim.Error("Failed to query materials: " + err.Error())
+	}
+	pageJSON, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query materials")
+	return shim.Success(pageJSON)
+}
+
+// ===============================================
+// getMaterialsByStatus - returns all materials of given lifecycle status, or one page of them
+// when the page size and bookmark are passed
+// ===============================================
+func (t *Studio) getMaterialsByStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query material by status")
+
+	if len(args) != 1 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting status of the material to query, optionally followed by page size and bookmark")
+	}
+
+	status := args[0]
+	if _, ok := materialTransitions[status]; !ok {
+		return shim.Error("Unknown material status: " + status)
+	}
+	if len(args) == 3 {
+		return getMaterialsPage(stub, materialStatusIndex, []string{status}, args[1:])
+	}
+
+	// Query the materialStatus~ID index by status
+	materials, err := getMaterialsFromIndex(stub, materialStatusIndex, []string{status})
+	if err != nil {
+		return shim.Error("Failed to get materials: " + err.Error())
+	}
+
+	// Marshal the materials list to JSON
+	materialsJSON, err := json.Marshal(materials)
+	if err != nil {
+		return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query material by status")
+	return shim.Success(materialsJSON)
+}
+
+// ===============================================
+// readMaterialPrivateDetails - read the private details of the given ID material.
+// Only members of a collection holding the details can read them, through a peer of their own organization.
+// ===============================================
+func (t *Studio) readMaterialPrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start read material private details")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material to query")
+	}
+
+	materialID := args[0]
+	material, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + materialID + ": " + err.Error())
+	} else if material == nil {
+		return shim.Error("Material does not exist: " + materialID)
+	}
+
+	// Checks the caller organization is a member of a collection holding the details
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Error getting MSP ID: " + err.Error())
+	}
+	collection := implicitCollectionPrefix + mspid
+	if !contains(material.PrivateDetailsCollections, collection) {
+		return unauthorized("unauthorized: organization " + mspid + " is not a member of the collections holding the private details of " + materialID)
+	}
+
+	// The peer only holds the implicit collection of its own organization
+	peerMSPID, err := shim.GetMSPID()
+	if err != nil {
+		return shim.Error("Error getting peer MSP ID: " + err.Error())
+	}
+	if peerMSPID != mspid {
+		return unauthorized("unauthorized: private details of organization " + mspid + " must be read through one of its peers")
+	}
+
+	privateDetailsKey, err := stub.CreateCompositeKey(materialPrivateDetailsObjectType, []string{materialID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	privateDetailsBytes, err := stub.GetPrivateData(collection, privateDetailsKey)
+	if err != nil {
+		return shim.Error("Failed to get private details for " + materialID + ": " + err.Error())
+	} else if privateDetailsBytes == nil {
+		return shim.Error("Material private details do not exist: " + materialID)
+	}
+
+	fmt.Println("- end read material private details")
+	return shim.Success(privateDetailsBytes)
+}
+
+// ===============================================
+// verifyMaterialPrivateDetails - checks the private details passed in the transient map against
+// the hashes saved on the channel, so organizations outside the collections can verify them
+// ===============================================
+func (t *Studio) verifyMaterialPrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start verify material private details")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material to verify")
+	}
+
+	materialID := args[0]
+	material, err := getMaterial(stub, materialID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + materialID + ": " + err.Error())
+	} else if material == nil {
+		return shim.Error("Material does not exist: " + materialID)
+	}
+
+	privateDetails, err := getMaterialPrivateDetailsFromTransient(stub, materialID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if privateDetails == nil {
+		return shim.Error("Expecting the private details to verify in the transient map under " + materialPrivateDetailsTransientKey)
+	}
+
+	// The details are hashed as they are saved by initMaterial
+	privateDetailsBytes, err := json.Marshal(privateDetails)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	hash := sha256.Sum256(privateDetailsBytes)
+
+	privateDetailsKey, err := stub.CreateCompositeKey(materialPrivateDetailsObjectType, []string{materialID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	verified := false
+	collections := make(map[string]bool)
+	for _, collection := range material.PrivateDetailsCollections {
+		savedHash, err := stub.GetPrivateDataHash(collection, privateDetailsKey)
+		if err != nil {
+			return shim.Error("Failed to get private details hash from " + collection + ": " + err.Error())
+		}
+		collections[collection] = bytes.Equal(savedHash, hash[:])
+		verified = verified || collections[collection]
+	}
+
+	resultJSON, err := json.Marshal(map[string]interface{}{
+		"ID":          materialID,
+		"verified":    verified,
+		"collections": collections,
+	})
+	if err != nil {
+		return shim.Error("Failed to marshal verification result to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end verify material private details")
+	return shim.Success(resultJSON)
+}
+
+// ===============================================
+// getAllMaterialsAndIndexList - returns all materials and index list
+// ===============================================
+func (t *Studio) getAllMaterialsAndIndexList(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start get all materials and index list")
+
+	// Retrieve the material index entries
+	materialIndexList, err := getIndexKeys(stub, materialTypeIndex, []string{})
+	if err != nil {
+		return shim.Error("Failed to get material index list: " + err.Error())
+	}
+
+	// Retrieve all materials
+	allMaterials, err := getMaterialsFromIndex(stub, materialTypeIndex, []string{})
+	if err != nil {
+		return shim.Error("Failed to get materials: " + err.Error())
+	}
+
+	// Combine materials list and index list into a single JSON response
+	responseData := struct {
+		Materials []Material `json:"materials"`
+		IndexList []string   `json:"index_list"`
+	}{
+		Materials: allMaterials,
+		IndexList: materialIndexList,
+	}
+	responseDataJSON, err := json.Marshal(responseData)
+	if err != nil {
+		return shim.Error("Failed to marshal response data to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end get all materials and index list")
+	return shim.Success(responseDataJSON)
+}
+
+// ===============================================
+// getMaterialsFromIndex - returns the materials whose index entries match the given attributes.
+// The material ID must be the last attribute of the index.
+// ===============================================
+func getMaterialsFromIndex(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]Material, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return getMaterialsFromIterator(stub, resultsIterator)
+}
+
+// ===============================================
+// getMaterialsPage - returns one page of the materials whose index entries match the given attributes.
+// paginationArgs holds the page size and the bookmark returned with the previous page.
+// ===============================================
+func getMaterialsPage(stub shim.ChaincodeStubInterface, indexName string, attributes []string, paginationArgs []string) pb.Response {
+	pageSize, bookmark, err := parsePagination(paginationArgs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, responseMetadata, err := getIndexPage(stub, indexName, attributes, pageSize, bookmark)
+	if err != nil {
+		return shim.Error("Failed to get materials: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	materials, err := getMaterialsFromIterator(stub, resultsIterator)
+	if err != nil {
+		return shim.Error("Failed to get materials: " + err.Error())
+	}
+	if materials == nil {
+		materials = []Material{}
+	}
+
+	pageJSON, err := json.Marshal(newPage(materials, len(materials), responseMetadata))
+	if err != nil {
+		return shim.Error("Failed to marshal materials to JSON: " + err.Error())
+	}
+	return shim.Success(pageJSON)
+}
+
+// ===============================================
+// getMaterialsFromIterator - returns the materials of the index entries returned by the iterator.
+// The material ID must be the last attribute of the index.
+// ===============================================
+func getMaterialsFromIterator(stub shim.ChaincodeStubInterface, resultsIterator shim.StateQueryIteratorInterface) ([]Material, error) {
+	var materials []Material
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// get the ID from the last attribute of the composite key
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		materialID := compositeKeyParts[len(compositeKeyParts)-1]
+
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return nil, err
+		}
+		if material == nil {
+			// Ignore index entries pointing to missing materials
+			continue
+		}
+		materials = append(materials, *material)
+	}
+
+	return materials, nil
+}
+
+// materialScan is the index queryMaterials reads for a filter, and the bounds of its creation time range
+type materialScan struct {
+	indexName string
+	prefix    []string  // leading attributes of the entries read
+	months    []string  // creation months read after the prefix, none to read every entry of the prefix
+	sort      string    // order of the entries, createdAt for the month indexes and ID for the status index
+	from      time.Time // inclusive, zero when not set
+	to        time.Time // exclusive, zero when not set
+}
+
+// buckets returns the months read in order, or a single empty bucket when every entry of the prefix is read
+func (scan *materialScan) buckets() []string {
+	if len(scan.months) == 0 {
+		return []string{""}
+	}
+	return scan.months
+}
+
+// attributes returns the partial composite key of the index entries of a bucket
+func (scan *materialScan) attributes(bucket string) []string {
+	attributes := append([]string{}, scan.prefix...)
+	if bucket != "" {
+		attributes = append(attributes, bucket)
+	}
+	return attributes
+}
+
+// ===============================================
+// planMaterialScan - picks the index queryMaterials reads for the filter. The supplier, type, status
+// and creation month indexes are tried in this order, the first one the filter sets is read.
+// Filters that no index narrows are refused with field errors, instead of reading every material.
+// ===============================================
+func planMaterialScan(stub shim.ChaincodeStubInterface, filter *MaterialFilter) (*materialScan, []FieldError, error) {
+	scan := &materialScan{indexName: materialMonthIndex, sort: "createdAt"}
+	var err error
+	if filter.CreatedFrom != "" {
+		if scan.from, err = time.Parse(time.RFC3339Nano, filter.CreatedFrom); err != nil {
+			return nil, nil, err
+		}
+	}
+	if filter.CreatedTo != "" {
+		if scan.to, err = time.Parse(time.RFC3339Nano, filter.CreatedTo); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// A range without end runs up to the transaction time
+	if !scan.from.IsZero() {
+		end := scan.to
+		if end.IsZero() {
+			txTimestamp, err := stub.GetTxTimestamp()
+			if err != nil {
+				return nil, nil, err
+			}
+			end = time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos))
+		}
+		scan.months = creationMonths(scan.from, end)
+	}
+
+	switch {
+	case filter.Supplier != "":
+		scan.indexName, scan.prefix = materialSupplierMonthIndex, []string{filter.Supplier}
+	case filter.Type != "":
+		scan.indexName, scan.prefix = materialTypeMonthIndex, []string{filter.Type}
+	case filter.Status != "":
+		scan.indexName, scan.prefix, scan.months, scan.sort = materialStatusIndex, []string{filter.Status}, nil, "ID"
+	case len(scan.months) == 0 && !scan.from.IsZero():
+		return nil, []FieldError{{Field: "createdFrom", Error: fmt.Sprintf("range spans more than %d months, also set supplier, type or status", maxQueryMonths)}}, nil
+	case len(scan.months) == 0:
+		return nil, []FieldError{{Field: "", Error: "must set supplier, type, status or createdFrom"}}, nil
+	}
+
+	return scan, nil, nil
+}
+
+// ===============================================
+// queryMaterialsByFilter - returns the sorted materials matching the filter, read from the planned index
+// ===============================================
+func queryMaterialsByFilter(stub shim.ChaincodeStubInterface, scan *materialScan, filter *MaterialFilter) ([]Material, error) {
+	materials := []Material{}
+	for _, bucket := range scan.buckets() {
+		indexMaterials, err := getMaterialsFromIndex(stub, scan.indexName, scan.attributes(bucket))
+		if err != nil {
+			return nil, err
+		}
+		for _, material := range indexMaterials {
+			if matchesMaterialFilter(&material, filter, scan.from, scan.to) {
+				materials = append(materials, material)
+			}
+		}
+	}
+
+	// ==== Sort by the requested field, then by ID ====
+	createdAt := make(map[string]time.Time)
+	for _, material := range materials {
+		// Materials saved by older versions have no creation time and come first
+		created, _ := time.Parse(time.RFC3339Nano, material.CreatedAt)
+		createdAt[material.ID] = created
+	}
+	sort.SliceStable(materials, func(i, j int) bool {
+		if filter.Sort == "createdAt" && !createdAt[materials[i].ID].Equal(createdAt[materials[j].ID]) {
+			if filter.Order == "desc" {
+				return createdAt[materials[i].ID].After(createdAt[materials[j].ID])
+			}
+			return createdAt[materials[i].ID].Before(createdAt[materials[j].ID])
+		}
+		if filter.Order == "desc" {
+			return materials[i].ID > materials[j].ID
+		}
+		return materials[i].ID < materials[j].ID
+	})
+
+	return materials, nil
+}
+
+// ===============================================
+// queryMaterialsPage - returns one page of the materials matching the filter, in the order of the planned index.
+// At most pageSize index entries are read, so a page holds fewer materials when the other criteria drop some.
+// The bookmark is the month being read and the bookmark of the index in that month, separated by "|".
+// ===============================================
+func queryMaterialsPage(stub shim.ChaincodeStubInterface, scan *materialScan, filter *MaterialFilter, pageSize int32, bookmark string) (*Page, error) {
+	buckets := scan.buckets()
+	first, indexBookmark := 0, ""
+	if bookmark != "" {
+		bucket, rest, found := strings.Cut(bookmark, "|")
+		first = -1
+		for i := range buckets {
+			if found && buckets[i] == bucket {
+				first = i
+				break
+			}
+		}
+		if first < 0 {
+			return nil, fmt.Errorf("invalid bookmark, it does not belong to this filter")
+		}
+		indexBookmark = rest
+	}
+
+	match := func(material *Material) bool {
+		return matchesMaterialFilter(material, filter, scan.from, scan.to)
+	}
+
+	materials := []Material{}
+	nextBookmark := ""
+	var read int32
+	for i := first; i < len(buckets) && read < pageSize; i++ {
+		requested := pageSize - read
+		bucketMaterials, bucketRead, bucketBookmark, err := getMaterialsPageFromIndex(stub, scan.indexName, scan.attributes(buckets[i]), requested, indexBookmark, match)
+		if err != nil {
+			return nil, err
+		}
+		materials = append(materials, bucketMaterials...)
+		read += bucketRead
+		indexBookmark = ""
+
+		// A full read may have left entries in the month, a shorter one exhausted it
+		if bucketRead == requested && bucketBookmark != "" {
+			nextBookmark = buckets[i] + "|" + bucketBookmark
+		} else if read == pageSize && i+1 < len(buckets) {
+			nextBookmark = buckets[i+1] + "|"
+		}
+	}
+
+	return &Page{Records: materials, FetchedRecordsCount: int32(len(materials)), Bookmark: nextBookmark}, nil
+}
+
+// ===============================================
+// getMaterialsPageFromIndex - reads one page of the index entries matching the given attributes and returns
+// the materials the match function keeps, the number of entries read and the bookmark of the index
+// ===============================================
+func getMaterialsPageFromIndex(stub shim.ChaincodeStubInterface, indexName string, attributes []string, pageSize int32, bookmark string, match func(material *Material) bool) ([]Material, int32, string, error) {
+	resultsIterator, responseMetadata, err := getIndexPage(stub, indexName, attributes, pageSize, bookmark)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer resultsIterator.Close()
+
+	materials := []Material{}
+	var read int32
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, 0, "", err
+		}
+		read++
+
+		// get the ID from the last attribute of the composite key
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		material, err := getMaterial(stub, compositeKeyParts[len(compositeKeyParts)-1])
+		if err != nil {
+			return nil, 0, "", err
+		}
+		// Ignore index entries pointing to missing materials
+		if material != nil && match(material) {
+			materials = append(materials, *material)
+		}
+	}
+
+	nextBookmark := ""
+	if responseMetadata != nil {
+		nextBookmark = responseMetadata.Bookmark
+	}
+	return materials, read, nextBookmark, nil
+}
+
+// ===============================================
+// matchesMaterialFilter - tells if the material matches every criterion of the filter
+// ===============================================
+func matchesMaterialFilter(material *Material, filter *MaterialFilter, from time.Time, to time.Time) bool {
+	if filter.Type != "" && material.Type != filter.Type {
+		return false
+	}
+	if filter.Supplier != "" && material.Supplier != filter.Supplier {
+		return false
+	}
+	if filter.Status != "" && material.Status != filter.Status {
+		return false
+	}
+	if filter.IDPrefix != "" && !strings.HasPrefix(material.ID, filter.IDPrefix) {
+		return false
+	}
+	if !from.IsZero() || !to.IsZero() {
+		created, err := time.Parse(time.RFC3339Nano, material.CreatedAt)
+		if err != nil {
+			return false
+		}
+		if !from.IsZero() && created.Before(from) {
+			return false
+		}
+		if !to.IsZero() && !created.Before(to) {
+			return false
+		}
+	}
+	return true
+}
+
+// ===============================================
+// creationMonth - returns the month bucket of the material creation time, empty for materials
+// saved by older versions, which have no creation time
+// ===============================================
+func creationMonth(material *Material) string {
+	created, err := time.Parse(time.RFC3339Nano, material.CreatedAt)
+	if err != nil {
+		return ""
+	}
+	return created.UTC().Format(monthLayout)
+}
+
+// ===============================================
+// creationTimeKey - returns the material creation time in the fixed-width timeKeyLayout, so the month
+// index entries sort by creation time. Empty for materials saved by older versions.
+// ===============================================
+func creationTimeKey(material *Material) string {
+	created, err := time.Parse(time.RFC3339Nano, material.CreatedAt)
+	if err != nil {
+		return ""
+	}
+	return created.UTC().Format(timeKeyLayout)
+}
+
+// ===============================================
+// creationMonths - returns the month buckets from the month of from to the month of to.
+// Returns none when the range spans more than maxQueryMonths.
+// ===============================================
+func creationMonths(from time.Time, to time.Time) []string {
+	months := []string{}
+	month := time.Date(from.UTC().Year(), from.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !month.After(to.UTC()) {
+		if len(months) == maxQueryMonths {
+			return []string{}
+		}
+		months = append(months, month.Format(monthLayout))
+		month = month.AddDate(0, 1, 0)
+	}
+	return months
+}
+
+// ===============================================
+// getMaterial - reads the ID given material from its Material~ID key.
+// Returns nil if it does not exist and an error if the key holds another docType.
+// ===============================================
+func getMaterial(stub shim.ChaincodeStubInterface, materialID string) (*Material, error) {
+	materialKey, err := stub.CreateCompositeKey(materialObjectType, []string{materialID})
+	if err != nil {
+		return nil, err
+	}
+
+	materialBytes, err := stub.GetState(materialKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get material %s: %s", materialID, err)
+	}
+	if materialBytes == nil {
+		return nil, nil
+	}
+
+	var material Material
+	err = json.Unmarshal(materialBytes, &material)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal material %s: %s", materialID, err)
+	}
+	if material.ObjectType != materialObjectType {
+		return nil, fmt.Errorf("document %s is a %s, not a %s", materialID, material.ObjectType, materialObjectType)
+	}
+
+	return &material, nil
+}
+
+// ===============================================
+// putMaterial - saves the material under its Material~ID key, bumping its version, and keeps its
+// index entries in sync. previous is the material as currently saved in state, nil for a new or migrated material.
+// created is only set by initMaterial and initMaterialsBatch, when the material is created by the transaction.
+// ===============================================
+func putMaterial(stub shim.ChaincodeStubInterface, material *Material, previous *Material, created bool) error {
+	materialKey, err := stub.CreateCompositeKey(materialObjectType, []string{material.ID})
+	if err != nil {
+		return err
+	}
+
+	if err = stampMetadata(stub, &material.Metadata, created); err != nil {
+		return err
+	}
+	material.Version++
+
+	materialJSONasBytes, err := json.Marshal(material)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(materialKey, materialJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	// Old index entries are deleted before the new ones are saved, an entry present in
+	// both is deleted and saved again, which leaves it in state
+	if previous != nil {
+		previousIndexKeys, err := materialIndexKeys(stub, previous)
+		if err != nil {
+			return err
+		}
+		for _, indexKey := range previousIndexKeys {
+			if err = stub.DelState(indexKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	indexKeys, err := materialIndexKeys(stub, material)
+	if err != nil {
+		return err
+	}
+	for _, indexKey := range indexKeys {
+		// Only the key name is needed, no need to store a duplicate copy of the material.
+		// Note - passing a 'nil' value will effectively delete the key from state, therefore we pass a null character as value
+		if err = stub.PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ===============================================
+// delMaterial - removes the material, its index entries and its private details from state
+// ===============================================
+func delMaterial(stub shim.ChaincodeStubInterface, material *Material) error {
+	materialKey, err := stub.CreateCompositeKey(materialObjectType, []string{material.ID})
+	if err != nil {
+		return err
+	}
+	if err = stub.DelState(materialKey); err != nil {
+		return err
+	}
+
+	privateDetailsKey, err := stub.CreateCompositeKey(materialPrivateDetailsObjectType, []string{material.ID})
+	if err != nil {
+		return err
+	}
+	for _, collection := range material.PrivateDetailsCollections {
+		if err = stub.DelPrivateData(collection, privateDetailsKey); err != nil {
+			return err
+		}
+	}
+
+	indexKeys, err := materialIndexKeys(stub, material)
+	if err != nil {
+		return err
+	}
+	for _, indexKey := range indexKeys {
+		if err = stub.DelState(indexKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ===============================================
+// getMaterialPrivateDetailsFromTransient - returns the private details passed in the transient map
+// for the given material ID, nil if there are none
+// ===============================================
+func getMaterialPrivateDetailsFromTransient(stub shim.ChaincodeStubInterface, materialID string) (*MaterialPrivateDetails, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %s", err)
+	}
+
+	privateDetailsBytes, ok := transientMap[materialPrivateDetailsTransientKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var privateDetails MaterialPrivateDetails
+	err = json.Unmarshal(privateDetailsBytes, &privateDetails)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON of %s: %s", materialPrivateDetailsTransientKey, err)
+	}
+	if privateDetails.UnitPrice < 0 {
+		return nil, fmt.Errorf("unitPrice cannot be negative")
+	}
+
+	// The private details always belong to the given material
+	privateDetails.ObjectType = materialPrivateDetailsObjectType
+	privateDetails.ID = materialID
+
+	return &privateDetails, nil
+}
+
+// ===============================================
+// putMaterialPrivateDetails - saves the private details in the given collection
+// ===============================================
+func putMaterialPrivateDetails(stub shim.ChaincodeStubInterface, collection string, privateDetails *MaterialPrivateDetails) error {
+	privateDetailsKey, err := stub.CreateCompositeKey(materialPrivateDetailsObjectType, []string{privateDetails.ID})
+	if err != nil {
+		return err
+	}
+
+	privateDetailsBytes, err := json.Marshal(privateDetails)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutPrivateData(collection, privateDetailsKey, privateDetailsBytes)
+}
+
+// ===============================================
+// materialIndexKeys - returns the index entries of the material.
+// The composite keys are based on indexName~attribute~ID, enabling very efficient
+// range queries on keys matching indexName~attribute~*
+// ===============================================
+func materialIndexKeys(stub shim.ChaincodeStubInterface, material *Material) ([]string, error) {
+	// Deleted materials are hidden from every index
+	if material.Deleted != nil {
+		return nil, nil
+	}
+
+	statusIndexKey, err := stub.CreateCompositeKey(materialStatusIndex, []string{material.Status, material.ID})
+	if err != nil {
+		return nil, err
+	}
+	indexKeys := []string{statusIndexKey}
+
+	// Every material is indexed by creation month, alone and with its supplier and its type, for queryMaterials.
+	// The creation time follows the month, so the entries of a month sort by creation time.
+	month := creationMonth(material)
+	created := creationTimeKey(material)
+	for _, monthIndex := range []struct {
+		name       string
+		attributes []string
+	}{
+		{materialSupplierMonthIndex, []string{material.Supplier, month, created, material.ID}},
+		{materialTypeMonthIndex, []string{material.Type, month, created, material.ID}},
+		{materialMonthIndex, []string{month, created, material.ID}},
+	} {
+		monthIndexKey, err := stub.CreateCompositeKey(monthIndex.name, monthIndex.attributes)
+		if err != nil {
+			return nil, err
+		}
+		indexKeys = append(indexKeys, monthIndexKey)
+	}
+
+	// Only materials available for wand production are indexed by type
+	if material.Status == statusAvailable {
+		typeIndexKey, err := stub.CreateCompositeKey(materialTypeIndex, []string{material.Type, material.ID})
+		if err != nil {
+			return nil, err
+		}
+		indexKeys = append(indexKeys, typeIndexKey)
+	}
+
+	// Materials point to the wand that consumed them and to the wands they left, so recalled materials
+	// and suppliers can be traced to every wand they were part of
+	var wandIDs []string
+	for _, record := range material.Lineage {
+		if !contains(wandIDs, record.WandID) {
+			wandIDs = append(wandIDs, record.WandID)
+		}
+	}
+	if material.ConsumedBy != "" && !contains(wandIDs, material.ConsumedBy) {
+		wandIDs = append(wandIDs, material.ConsumedBy)
+	}
+	for _, wandID := range wandIDs {
+		wandIndexKey, err := stub.CreateCompositeKey(materialWandIndex, []string{material.ID, wandID})
+		if err != nil {
+			return nil, err
+		}
+		supplierIndexKey, err := stub.CreateCompositeKey(supplierWandIndex, []string{material.Supplier, wandID, material.ID})
+		if err != nil {
+			return nil, err
+		}
+		indexKeys = append(indexKeys, wandIndexKey, supplierIndexKey)
+	}
+
+	return indexKeys, nil
+}
+
+// ===============================================
+// checkMaterialTransition - checks that the material can move to the given status
+// ===============================================
+func checkMaterialTransition(material *Material, status string) error {
+	if material.Deleted != nil {
+		return fmt.Errorf("material %s is deleted", material.ID)
+	}
+	next, ok := materialTransitions[material.Status]
+	if !ok {
+		return fmt.Errorf("material %s has unknown status %s", material.ID, material.Status)
+	}
+	if _, ok := materialTransitions[status]; !ok {
+		return fmt.Errorf("unknown material status: %s", status)
+	}
+	if !contains(next, status) {
+		return fmt.Errorf("material %s cannot move from %s to %s", material.ID, material.Status, status)
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções wands
+
+// ============================================================
+// initWand - create a new wand, store into chaincode state
+// ============================================================
+func (t *Studio) initWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var err error
+
+	// ==== Input sanitation ====
+	fmt.Println("- start init wand")
+
+	// A single argument is the JSON document described by schemas/initWand.json
+	if len(args) == 1 {
+		var fieldErrors []FieldError
+		args, fieldErrors, err = initWandArgsFromDocument(args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(fieldErrors) > 0 {
+			return unprocessable("invalid initWand document", fieldErrors)
+		}
+	}
+
+	// Checks the correct number of arguments
+	if len(args) < 6 {
+		return shim.Error("Incorrect number of arguments. Expecting ID, Type, Color, Size and a list of Materials, or a JSON document.")
+	}
+
+	// Extracting the arguments
+	wandID := args[0]
+	wandType := args[1]
+	wandColor := args[2]
+	wandSize, err := strconv.Atoi(args[3])
+	if err != nil || wandSize < 1 {
+		return shim.Error("Size must be a positive integer.")
+	}
+
+	num_materials, err := strconv.Atoi(args[4])
+	var materials []string
+	if err != nil {
+		return shim.Error("Erro ao converter num_materials para inteiro: " + err.Error())
+	}
+
+	// MaterialCount must match the materials passed, so none is ignored
+	if num_materials != len(args)-5 {
+		return shim.Error(fmt.Sprintf("MaterialCount is %d but %d materials were passed", num_materials, len(args)-5))
+	}
+
+	// Loop que vai do 5 até 5 + num_materials
+	for i := 5; i < 5+num_materials; i++ {
+		materials = append(materials, args[i])
+	}
+
+	// Checks that the wand ID is not empty
+	if wandID == "" {
+		return shim.Error("Wand ID cannot be empty")
+	}
+
+	// Checks if wand with given ID already exists
+	existingWand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get wand: " + err.Error())
+	} else if existingWand != nil && existingWand.Deleted != nil {
+		return shim.Error("This wand was deleted, its ID cannot be reused: " + wandID)
+	} else if existingWand != nil {
+		fmt.Println("This wand already exists: " + wandID)
+		return shim.Error("This wand already exists: " + wandID)
+	}
+
+	// Checks that every material can be consumed by the wand
+	var consumedMaterials []*Material
+	usedMaterials := make(map[string]bool)
+	for _, materialID := range materials {
+		// A material can only be used once in the same wand
+		if usedMaterials[materialID] {
+			return shim.Error("Material ID repeated in the wand materials: " + materialID)
+		}
+		usedMaterials[materialID] = true
+
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if material == nil {
+			return shim.Error("Material not found: " + materialID)
+		}
+
+		// Only available or reserved materials can be consumed
+		err = checkMaterialTransition(material, statusConsumed)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		consumedMaterials = append(consumedMaterials, material)
+	}
+
+	// The materials must follow the recipe of the wand type
+	violations, err := checkWandRecipe(stub, wandType, consumedMaterials)
+	if err != nil {
+		return shim.Error("Failed to check recipe: " + err.Error())
+	}
+	if len(violations) > 0 {
+		return unprocessable("materials of wand "+wandID+" do not follow the recipe of type "+wandType, violations)
+	}
+
+	// Creates a Wand
+	wand := &Wand{
+		ObjectType: wandObjectType,
+		ID:         wandID,
+		Type:       wandType,
+		Color:      wandColor,
+		Size:       wandSize,
+		Materials:  materials,
+		Status:     wandStatusInStock,
+	}
+
+	// Save the wand in the world state and index it by type and status
+	err = putWand(stub, wand, nil, true)
+	if err != nil {
+		return shim.Error("Erro ao salvar a varinha no estado do world state: " + err.Error())
+	}
+
+	record, err := newEventRecord(eventWandCreated, wandObjectType, wand.ID, wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records := []EventRecord{record}
+
+	// Marks the materials as consumed by the wand, which also removes them from the available materials index
+	for _, material := range consumedMaterials {
+		previous := *material
+		material.Status = statusConsumed
+		material.ConsumedBy = wand.ID
+		err = putMaterial(stub, material, &previous, false)
+		if err != nil {
+			return shim.Error("Failed to update material " + material.ID + ": " + err.Error())
+		}
+
+		record, err = newEventRecord(eventMaterialConsumed, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// Notify listeners of the new wand and of the materials it consumed
+	err = setEvent(stub, eventWandCreated, records...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Returns a success message
+	fmt.Println("- end init wand")
+	return shim.Success(nil)
+}
+
+// ===============================================
+// readWand - read the ID given wand from chaincode state
+// ===============================================
+func (t *Studio) readWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var ID string
+	var err error
+
+	fmt.Println("Checking the number of arguments...")
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand to query")
+	}
+
+	ID = args[0]
+	wand, err := getWand(stub, ID) //get the wand from chaincode state
+	if err != nil {
+		return shim.Error("Failed to get state for " + ID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + ID)
+	}
+
+	valAsbytes, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error("Failed to marshal wand to JSON: " + err.Error())
+	}
+
+	return shim.Success(valAsbytes)
+}
+
+// ===============================================
+// getWandProvenance - returns the ID given wand together with its materials, their suppliers,
+// who registered them and the transactions that created and consumed them
+// ===============================================
+func (t *Studio) getWandProvenance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start get wand provenance")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand to query")
+	}
+
+	wandID := args[0]
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+
+	provenance := WandProvenance{Wand: *wand, Materials: []MaterialProvenance{}}
+	for _, materialID := range wand.Materials {
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if material == nil {
+			return shim.Error("Material not found: " + materialID)
+		}
+
+		materialProvenance, err := getMaterialProvenance(stub, material, wandID)
+		if err != nil {
+			return shim.Error("Failed to get history of material " + materialID + ": " + err.Error())
+		}
+		provenance.Materials = append(provenance.Materials, *materialProvenance)
+	}
+
+	for _, repair := range wand.Repairs {
+		for _, replacement := range repair.Replacements {
+			material, err := getMaterial(stub, replacement.Removed)
+			if err != nil {
+				return shim.Error("Failed to get material details: " + err.Error())
+			}
+			if material == nil {
+				// Removed materials may be deleted once written off
+				continue
+			}
+
+			materialProvenance, err := getMaterialProvenance(stub, material, wandID)
+			if err != nil {
+				return shim.Error("Failed to get history of material " + replacement.Removed + ": " + err.Error())
+			}
+			provenance.RemovedMaterials = append(provenance.RemovedMaterials, *materialProvenance)
+		}
+	}
+
+	provenanceJSON, err := json.Marshal(provenance)
+	if err != nil {
+		return shim.Error("Failed to marshal wand provenance to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end get wand provenance")
+	return shim.Success(provenanceJSON)
+}
+
+// ===============================================
+// getMaterialProvenance - walks the history of the material to find the transactions that
+// registered it and that consumed it in the given wand. The registration comes from the created
+// metadata when the material has it. Fields the history cannot tell, such as the consumption of a
+// material consumed before the migration, are left empty.
+// ===============================================
+func getMaterialProvenance(stub shim.ChaincodeStubInterface, material *Material, wandID string) (*MaterialProvenance, error) {
+	history, err := getDocumentHistory(stub, materialObjectType, material.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	provenance := &MaterialProvenance{
+		Material:       *material,
+		Supplier:       material.Supplier,
+		RegisteredBy:   material.CreatedBy,
+		RegisteredTxID: material.CreatedTxID,
+		RegisteredAt:   material.CreatedAt,
+	}
+
+	// The migration moved the documents of older versions in one transaction that deleted the raw key
+	// and saved the namespaced one. The move is not a deletion, and the version it saved is not a change.
+	moves := historyMoves(history)
+
+	registeredTxID, registeredAt := "", ""
+	consumed := false
+	for _, entry := range history {
+		if entry.IsDelete {
+			if moves[entry.TxID] {
+				continue
+			}
+			// The ID was deleted and registered again, only the last registration counts
+			registeredTxID, registeredAt = "", ""
+			provenance.ConsumedTxID, provenance.ConsumedAt = "", ""
+			consumed = false
+			continue
+		}
+
+		var version Material
+		if err = json.Unmarshal(entry.Value, &version); err != nil {
+			return nil, err
+		}
+		consumedByWand := version.Status == statusConsumed && version.ConsumedBy == wandID
+		if !moves[entry.TxID] {
+			if registeredTxID == "" {
+				registeredTxID, registeredAt = entry.TxID, entry.Timestamp
+			}
+			if consumedByWand && !consumed {
+				provenance.ConsumedTxID, provenance.ConsumedAt = entry.TxID, entry.Timestamp
+			}
+		}
+		consumed = consumedByWand
+	}
+	if provenance.RegisteredTxID == "" {
+		provenance.RegisteredTxID, provenance.RegisteredAt = registeredTxID, registeredAt
+	}
+
+	return provenance, nil
+}
+
+// ===============================================
+// getWandsByType - returns all Wands of given type, or one page of them
+// when the page size and bookmark are passed
+// ===============================================
+func (t *Studio) getWandsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- Start query wands by type")
+
+	if len(args) != 1 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting type of the wand to query, optionally followed by page size and bookmark")
+	}
+
+	typeIndex := args[0]
+	if len(args) == 3 {
+		return getWandsPage(stub, wandTypeIndex, []string{typeIndex}, args[1:])
+	}
+
+	// Query the wandType~ID index by type
+	wands, err := getWandsFromIndex(stub, wandTypeIndex, []string{typeIndex})
+	if err != nil {
+		return shim.Error("Failed to get wands: " + err.Error())
+	}
+
+	// Marshal the wands list to JSON
+	wandsJSON, err := json.Marshal(wands)
+	if err != nil {
+		return shim.Error("Failed to marshal wands to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query wand by type")
+	return shim.Success(wandsJSON)
+}
+
+// ===============================================
+// getAllWands - returns all wands, or one page of them when the page size and bookmark are passed
+// ===============================================
+func (t *Studio) getAllWands(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query all available wands")
+
+	if len(args) != 0 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting no arguments, or page size and bookmark")
+	}
+	if len(args) == 2 {
+		return getWandsPage(stub, wandTypeIndex, []string{}, args)
+	}
+
+	// Query the wandType~ID index with no type, matching every wand
+	wands, err := getWandsFromIndex(stub, wandTypeIndex, []string{})
+	if err != nil {
+		return shim.Error("Failed to get wands: " + err.Error())
+	}
+
+	// Marshal the wands list to JSON
+	wandsJSON, err := json.Marshal(wands)
+	if err != nil {
+		return shim.Error("Failed to marshal wands to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query all available wands")
+	return shim.Success(wandsJSON)
+}
+
+// ===============================================
+// getNumberWandsByType - returns number of wands of given type
+// ===============================================
+func (t *Studio) getNumberwandsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query number of wands by type")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting type of the wands to query the number")
+	}
+
+	typeIndex := args[0]
+
+	// Count the index entries of the given type, no need to read the wands
+	num_wands, err := countIndexKeys(stub, wandTypeIndex, []string{typeIndex})
+	if err != nil {
+		return shim.Error("Failed to get wand index: " + err.Error())
+	}
+
+	// Marshal the number of wands to JSON
+	numWandsJSON, err := json.Marshal(map[string]int{"num_wands": num_wands})
+	if err != nil {
+		return shim.Error("Failed to marshal number of wands to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query number of wands by type")
+	return shim.Success(numWandsJSON)
+}
+
+// ===============================================
+// getTotalNumberOfWandss- returns total number of wands
+// ===============================================
+func (t *Studio) getTotalNumberOfWands(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start query number of wands")
+
+	// Count every entry of the wand index
+	num_wands, err := countIndexKeys(stub, wandTypeIndex, []string{})
+	if err != nil {
+		return shim.Error("Failed to get wand index: " + err.Error())
+	}
+
+	// Marshal the number of wands to JSON
+	numWandsJSON, err := json.Marshal(map[string]int{"num_wands": num_wands})
+	if err != nil {
+		return shim.Error("Failed to marshal number of wands to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query number of wands")
+	return shim.Success(numWandsJSON)
+}
+
+// ==================================================
+// deleteWand - marks the given ID wand and its materials as deleted with tombstones. They are hidden
+// from the list queries but stay in state, so their IDs cannot be reused, until they are restored or purged.
+// ==================================================
+func (t *Studio) deletewand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start delete Wand")
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Wand ID must be passed, optionally followed by the reason.")
+	}
+
+	wandID := args[0]
+	reason := ""
+	if len(args) == 2 {
+		reason = args[1]
+	}
+
+	// Get the wand details from the world state
+	wandToDelete, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wandToDelete == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wandToDelete.Deleted != nil {
+		return shim.Error("Wand " + wandID + " is already deleted")
+	}
+
+	// Sold and dismantled wands stay on the ledger to keep their tracking data
+	if wandToDelete.Status == wandStatusSold || wandToDelete.Status == wandStatusDismantled {
+		return shim.Error("Wand " + wandID + " was " + wandToDelete.Status + " and cannot be deleted")
+	}
+
+	tombstone, err := newTombstone(stub, reason, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Save the tombstone, which removes the wand index entries
+	previous := *wandToDelete
+	wandToDelete.Deleted = tombstone
+	err = putWand(stub, wandToDelete, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to delete wand " + wandID + ": " + err.Error())
+	}
+
+	record, err := newEventRecord(eventWandDeleted, wandObjectType, wandToDelete.ID, wandToDelete)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records := []EventRecord{record}
+
+	// The materials of the wand are deleted with it
+	for _, materialID := range wandToDelete.Materials {
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if material == nil {
+			continue
+		}
+
+		// Only the materials consumed by this wand go away with it
+		if material.Status != statusConsumed || material.ConsumedBy != wandID {
+			return shim.Error("Material " + materialID + " is not consumed by wand " + wandID)
+		}
+
+		materialTombstone, err := newTombstone(stub, reason, wandID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		previous := *material
+		material.Deleted = materialTombstone
+		err = putMaterial(stub, material, &previous, false)
+		if err != nil {
+			return shim.Error("Failed to delete material " + materialID + ": " + err.Error())
+		}
+
+		record, err = newEventRecord(eventMaterialDeleted, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// Notify listeners of the deleted wand and of its deleted materials
+	err = setEvent(stub, eventWandDeleted, records...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end delete Wand")
+	return shim.Success(nil)
+}
+
+// ==================================================
+// restoreWand - removes the tombstones of the given ID deleted wand and of the materials deleted with it
+// ==================================================
+func (t *Studio) restoreWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start restore wand")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Wand ID must be passed.")
+	}
+
+	wandID := args[0]
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wand.Deleted == nil {
+		return shim.Error("Wand " + wandID + " is not deleted")
+	}
+
+	previous := *wand
+	wand.Deleted = nil
+	err = putWand(stub, wand, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to restore wand " + wandID + ": " + err.Error())
+	}
+
+	wandJSONasBytes, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records := []EventRecord{{Type: eventWandRestored, DocType: wandObjectType, ID: wand.ID, Document: wandJSONasBytes}}
+
+	for _, materialID := range wand.Materials {
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if material == nil || material.Deleted == nil || material.Deleted.WandID != wandID {
+			continue
+		}
+
+		previous := *material
+		material.Deleted = nil
+		err = putMaterial(stub, material, &previous, false)
+		if err != nil {
+			return shim.Error("Failed to restore material " + materialID + ": " + err.Error())
+		}
+
+		record, err := newEventRecord(eventMaterialRestored, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// Notify listeners of the restored wand and of its restored materials
+	err = setEvent(stub, eventWandRestored, records...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end restore wand")
+	return shim.Success(wandJSONasBytes)
+}
+
+// ==================================================
+// purgeWand - removes the given ID deleted wand and the materials deleted with it from state.
+// Their history is kept, but their IDs can be reused afterwards.
+// ==================================================
+func (t *Studio) purgeWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start purge wand")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Wand ID must be passed.")
+	}
+
+	wandID := args[0]
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wand.Deleted == nil {
+		return shim.Error("Wand " + wandID + " is not deleted")
+	}
+
+	err = delWand(stub, wand)
+	if err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+
+	record, err := newEventRecord(eventWandPurged, wandObjectType, wand.ID, wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records := []EventRecord{record}
+
+	for _, materialID := range wand.Materials {
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if material == nil || material.Deleted == nil || material.Deleted.WandID != wandID {
+			continue
+		}
+
+		err = delMaterial(stub, material)
+		if err != nil {
+			return shim.Error("Failed to delete state:" + err.Error())
+		}
+
+		record, err = newEventRecord(eventMaterialPurged, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// Notify listeners of the purged wand and of its purged materials
+	err = setEvent(stub, eventWandPurged, records...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end purge wand")
+	return shim.Success(nil)
+}
+
+// ===============================================
+// sellWand - sells the given ID in-stock wand to a buyer. The buyer becomes the first owner
+// of the wand, and the sale price, passed in the transient map, is kept in private data.
+// ===============================================
+func (t *Studio) sellWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start sell wand")
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand, buyer reference and shop")
+	}
+
+	wandID := args[0]
+	buyerRef := args[1]
+	shop := args[2]
+	if buyerRef == "" {
+		return shim.Error("Buyer reference cannot be empty")
+	}
+	if shop == "" {
+		return shim.Error("Shop cannot be empty")
+	}
+
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wand.Deleted != nil {
+		return shim.Error("Wand " + wandID + " is deleted")
+	}
+	if wand.Status != wandStatusInStock {
+		return shim.Error("Wand " + wandID + " is not in stock")
+	}
+
+	// The price comes in the transient map, so it stays out of the transaction
+	privateDetails, err := getWandSalePrivateDetailsFromTransient(stub, wandID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if privateDetails == nil {
+		return shim.Error("Expecting the sale price in the transient map under " + wandSalePrivateDetailsTransientKey)
+	}
+
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Error getting MSP ID: " + err.Error())
+	}
+	soldAt, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// === Save the price in the collection of the selling organization ===
+	sale := &Sale{
+		BuyerRef:                  buyerRef,
+		Shop:                      shop,
+		SoldAt:                    soldAt,
+		TxID:                      stub.GetTxID(),
+		PrivateDetailsCollections: []string{implicitCollectionPrefix + mspid},
+	}
+	for _, collection := range sale.PrivateDetailsCollections {
+		err = putWandSalePrivateDetails(stub, collection, privateDetails)
+		if err != nil {
+			return shim.Error("Failed to save sale private details in " + collection + ": " + err.Error())
+		}
+	}
+
+	previous := *wand
+	wand.Status = wandStatusSold
+	wand.Owner = buyerRef
+	wand.Sale = sale
+	wand.OwnershipChain = []OwnershipRecord{{Owner: buyerRef, Since: soldAt, TxID: sale.TxID}}
+	err = putWand(stub, wand, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to update wand " + wandID + ": " + err.Error())
+	}
+
+	wandJSONasBytes, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setEvent(stub, eventWandSold, EventRecord{Type: eventWandSold, DocType: wandObjectType, ID: wand.ID, Document: wandJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end sell wand")
+	return shim.Success(wandJSONasBytes)
+}
+
+// ===============================================
+// dismantleWand - dismantles the given ID in-stock wand. Its materials are released back to the
+// available stock, except the ones passed as destroyed, and every material records the wand in its lineage.
+// The wand and its materials are kept, so their provenance stays readable in history.
+// ===============================================
+func (t *Studio) dismantleWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start dismantle wand")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand and optionally a list of destroyed Materials")
+	}
+
+	wandID := args[0]
+	destroyed := args[1:]
+
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wand.Deleted != nil {
+		return shim.Error("Wand " + wandID + " is deleted")
+	}
+	if wand.Status != wandStatusInStock {
+		return shim.Error("Wand " + wandID + " is not in stock")
+	}
+
+	for i, materialID := range destroyed {
+		if !contains(wand.Materials, materialID) {
+			return shim.Error("Material " + materialID + " is not part of wand " + wandID)
+		}
+		if contains(destroyed[:i], materialID) {
+			return shim.Error("Material ID repeated in the destroyed materials: " + materialID)
+		}
+	}
+
+	dismantledBy, err := getCallerIdentity(stub)
+	if err != nil {
+		return shim.Error("Error getting caller identity: " + err.Error())
+	}
+	dismantledAt, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ==== Check every material before changing any ====
+	var materials []*Material
+	for _, materialID := range wand.Materials {
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if material == nil || material.Status != statusConsumed || material.ConsumedBy != wandID {
+			return shim.Error("Material " + materialID + " is not consumed by wand " + wandID)
+		}
+		materials = append(materials, material)
+	}
+
+	dismantling := &Dismantling{
+		DismantledAt: dismantledAt,
+		TxID:         stub.GetTxID(),
+		DismantledBy: dismantledBy,
+		Released:     []string{},
+		Destroyed:    []string{},
+	}
+	var records []EventRecord
+
+	// ==== Release or destroy the materials ====
+	for _, material := range materials {
+		previous := *material
+		recordType := eventMaterialReleased
+		material.Status = statusAvailable
+		if contains(destroyed, material.ID) {
+			recordType = eventMaterialDestroyed
+			material.Status = statusDestroyed
+		}
+		material.ConsumedBy = ""
+		material.Lineage = append(material.Lineage, LineageRecord{
+			WandID:    wandID,
+			Reason:    "dismantled",
+			Status:    material.Status,
+			TxID:      dismantling.TxID,
+			Timestamp: dismantledAt,
+		})
+		err = putMaterial(stub, material, &previous, false)
+		if err != nil {
+			return shim.Error("Failed to update material " + material.ID + ": " + err.Error())
+		}
+		if material.Status == statusDestroyed {
+			dismantling.Destroyed = append(dismantling.Destroyed, material.ID)
+		} else {
+			dismantling.Released = append(dismantling.Released, material.ID)
+		}
+
+		record, err := newEventRecord(recordType, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// ==== Mark the wand as dismantled ====
+	previous := *wand
+	wand.Status = wandStatusDismantled
+	wand.Dismantling = dismantling
+	err = putWand(stub, wand, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to update wand " + wandID + ": " + err.Error())
+	}
+
+	wandJSONasBytes, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records = append([]EventRecord{{Type: eventWandDismantled, DocType: wandObjectType, ID: wand.ID, Document: wandJSONasBytes}}, records...)
+
+	// Notify listeners of the dismantled wand and of what happened to its materials
+	err = setEvent(stub, eventWandDismantled, records...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end dismantle wand")
+	return shim.Success(wandJSONasBytes)
+}
+
+// ===============================================
+// repairWand - replaces materials of the given ID wand, passed as pairs of removed and installed
+// material IDs. The removed materials are written off and the repair is kept in the wand repairs list.
+// ===============================================
+func (t *Studio) repairWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start repair wand")
+
+	if len(args) < 4 || len(args)%2 != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand, reason and pairs of removed and replacement Materials")
+	}
+
+	wandID := args[0]
+	reason := args[1]
+	if reason == "" {
+		return shim.Error("Repair reason cannot be empty")
+	}
+
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wand.Deleted != nil {
+		return shim.Error("Wand " + wandID + " is deleted")
+	}
+	if wand.Status != wandStatusInStock && wand.Status != wandStatusSold {
+		return shim.Error("Wand " + wandID + " cannot be repaired, it is " + wand.Status)
+	}
+
+	// ==== Check every replacement before changing any material ====
+	var replacements []Replacement
+	var removedMaterials, installedMaterials []*Material
+	installedByID := make(map[string]*Material)
+	for i := 2; i < len(args); i += 2 {
+		replacement := Replacement{Removed: args[i], Installed: args[i+1]}
+		for _, other := range replacements {
+			if other.Removed == replacement.Removed {
+				return shim.Error("Material ID repeated in the removed materials: " + replacement.Removed)
+			}
+			if other.Installed == replacement.Installed {
+				return shim.Error("Material ID repeated in the replacement materials: " + replacement.Installed)
+			}
+		}
+		if !contains(wand.Materials, replacement.Removed) {
+			return shim.Error("Material " + replacement.Removed + " is not part of wand " + wandID)
+		}
+		if contains(wand.Materials, replacement.Installed) {
+			return shim.Error("Material " + replacement.Installed + " is already part of wand " + wandID)
+		}
+
+		removed, err := getMaterial(stub, replacement.Removed)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if removed == nil || removed.Status != statusConsumed || removed.ConsumedBy != wandID {
+			return shim.Error("Material " + replacement.Removed + " is not consumed by wand " + wandID)
+		}
+
+		installed, err := getMaterial(stub, replacement.Installed)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		}
+		if installed == nil {
+			return shim.Error("Material not found: " + replacement.Installed)
+		}
+		// Only available or reserved materials can be installed
+		err = checkMaterialTransition(installed, statusConsumed)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		replacements = append(replacements, replacement)
+		removedMaterials = append(removedMaterials, removed)
+		installedMaterials = append(installedMaterials, installed)
+		installedByID[installed.ID] = installed
+	}
+
+	// ==== The repaired wand must still follow the recipe of its type ====
+	materialIDs := make([]string, len(wand.Materials))
+	copy(materialIDs, wand.Materials)
+	for _, replacement := range replacements {
+		for i, materialID := range materialIDs {
+			if materialID == replacement.Removed {
+				materialIDs[i] = replacement.Installed
+			}
+		}
+	}
+	var materials []*Material
+	for _, materialID := range materialIDs {
+		material, ok := installedByID[materialID]
+		if !ok {
+			material, err = getMaterial(stub, materialID)
+			if err != nil {
+				return shim.Error("Failed to get material details: " + err.Error())
+			} else if material == nil {
+				return shim.Error("Material not found: " + materialID)
+			}
+		}
+		materials = append(materials, material)
+	}
+	violations, err := checkWandRecipe(stub, wand.Type, materials)
+	if err != nil {
+		return shim.Error("Failed to check recipe: " + err.Error())
+	}
+	if len(violations) > 0 {
+		return unprocessable("repaired wand "+wandID+" does not follow the recipe of type "+wand.Type, violations)
+	}
+
+	repairedBy, err := getCallerIdentity(stub)
+	if err != nil {
+		return shim.Error("Error getting caller identity: " + err.Error())
+	}
+	repairedAt, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	repair := Repair{
+		Reason:       reason,
+		RepairedAt:   repairedAt,
+		TxID:         stub.GetTxID(),
+		RepairedBy:   repairedBy,
+		Replacements: replacements,
+	}
+	var records []EventRecord
+
+	// ==== Write off the removed materials ====
+	for _, material := range removedMaterials {
+		previous := *material
+		material.Status = statusWrittenOff
+		material.ConsumedBy = ""
+		material.Lineage = append(material.Lineage, LineageRecord{
+			WandID:    wandID,
+			Reason:    "repaired",
+			Status:    material.Status,
+			TxID:      repair.TxID,
+			Timestamp: repairedAt,
+		})
+		err = putMaterial(stub, material, &previous, false)
+		if err != nil {
+			return shim.Error("Failed to update material " + material.ID + ": " + err.Error())
+		}
+
+		record, err := newEventRecord(eventMaterialRemoved, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// ==== Consume the replacement materials ====
+	for _, material := range installedMaterials {
+		previous := *material
+		material.Status = statusConsumed
+		material.ConsumedBy = wandID
+		err = putMaterial(stub, material, &previous, false)
+		if err != nil {
+			return shim.Error("Failed to update material " + material.ID + ": " + err.Error())
+		}
+
+		record, err := newEventRecord(eventMaterialConsumed, materialObjectType, material.ID, material)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	// ==== Record the repair on the wand ====
+	previous := *wand
+	wand.Materials = materialIDs
+	wand.Repairs = append(wand.Repairs, repair)
+	err = putWand(stub, wand, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to update wand " + wandID + ": " + err.Error())
+	}
+
+	wandJSONasBytes, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records = append([]EventRecord{{Type: eventWandRepaired, DocType: wandObjectType, ID: wand.ID, Document: wandJSONasBytes}}, records...)
+
+	// Notify listeners of the repaired wand and of its removed and installed materials
+	err = setEvent(stub, eventWandRepaired, records...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end repair wand")
+	return shim.Success(wandJSONasBytes)
+}
+
+// ===============================================
+// transferWand - moves the given ID sold wand to a new owner, appending it to the ownership chain
+// ===============================================
+func (t *Studio) transferWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start transfer wand")
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand and the new owner reference")
+	}
+
+	wandID := args[0]
+	newOwner := args[1]
+	if newOwner == "" {
+		return shim.Error("New owner reference cannot be empty")
+	}
+
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wand.Deleted != nil {
+		return shim.Error("Wand " + wandID + " is deleted")
+	}
+
+	// Wands in stock change hands through sellWand
+	if wand.Status != wandStatusSold {
+		return shim.Error("Wand " + wandID + " was not sold yet")
+	}
+	if wand.Owner == newOwner {
+		return shim.Error("Wand " + wandID + " is already owned by " + newOwner)
+	}
+
+	since, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	previous := *wand
+	wand.Owner = newOwner
+	wand.OwnershipChain = append(wand.OwnershipChain, OwnershipRecord{Owner: newOwner, Since: since, TxID: stub.GetTxID()})
+	err = putWand(stub, wand, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to update wand " + wandID + ": " + err.Error())
+	}
+
+	wandJSONasBytes, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setEvent(stub, eventWandTransferred, EventRecord{Type: eventWandTransferred, DocType: wandObjectType, ID: wand.ID, Document: wandJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end transfer wand")
+	return shim.Success(wandJSONasBytes)
+}
+
+// ===============================================
+// updateWand - corrects fields of the given ID wand with a partial JSON patch.
+// The update is refused when the wand is no longer at the expected version.
+// ===============================================
+func (t *Studio) updateWand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start update wand")
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand, JSON patch and expected version")
+	}
+
+	wandID := args[0]
+	patch := args[1]
+	expectedVersion, err := strconv.Atoi(args[2])
+	if err != nil {
+		return shim.Error("Expected version must be an integer")
+	}
+
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	}
+	if wand.Deleted != nil {
+		return shim.Error("Wand " + wandID + " is deleted")
+	}
+
+	if wand.Version != expectedVersion {
+		return conflict(fmt.Sprintf("stale update: wand %s is at version %d, not %d", wandID, wand.Version, expectedVersion))
+	}
+
+	// The consumed materials are immutable, only the wand's own attributes can be corrected
+	previous := *wand
+	fields, err := applyPatch(wand, patch, wandMutableFields)
+	if err != nil {
+		return shim.Error("Invalid patch for wand " + wandID + ": " + err.Error())
+	}
+	if len(fields) == 0 {
+		return shim.Error("Patch does not change wand " + wandID)
+	}
+	if wand.Type == "" {
+		return shim.Error("Wand type cannot be empty")
+	}
+	if wand.Size < 1 {
+		return shim.Error("Wand size must be a positive integer")
+	}
+
+	// A wand moved to another type must follow the recipe of the new type
+	if wand.Type != previous.Type {
+		var materials []*Material
+		for _, materialID := range wand.Materials {
+			material, err := getMaterial(stub, materialID)
+			if err != nil {
+				return shim.Error("Failed to get material details: " + err.Error())
+			} else if material == nil {
+				return shim.Error("Material not found: " + materialID)
+			}
+			materials = append(materials, material)
+		}
+		violations, err := checkWandRecipe(stub, wand.Type, materials)
+		if err != nil {
+			return shim.Error("Failed to check recipe: " + err.Error())
+		}
+		if len(violations) > 0 {
+			return unprocessable("materials of wand "+wandID+" do not follow the recipe of type "+wand.Type, violations)
+		}
+	}
+
+	change, err := newChange(stub, wand.Version+1, fields)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	wand.Changes = append(wand.Changes, change)
+
+	err = putWand(stub, wand, &previous, false)
+	if err != nil {
+		return shim.Error("Failed to update wand " + wandID + ": " + err.Error())
+	}
+
+	wandJSONasBytes, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setEvent(stub, eventWandUpdated, EventRecord{Type: eventWandUpdated, DocType: wandObjectType, ID: wand.ID, Document: wandJSONasBytes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end update wand")
+	return shim.Success(wandJSONasBytes)
+}
+
+// ===============================================
+// getWandsByStatus - returns all wands in stock, sold or dismantled, or one page of them
+// when the page size and bookmark are passed
+// ===============================================
+func (t *Studio) getWandsByStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query wands by status")
+
+	if len(args) != 1 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting status of the wand to query, optionally followed by page size and bookmark")
+	}
+
+	status := args[0]
+	if status != wandStatusInStock && status != wandStatusSold && status != wandStatusDismantled {
+		return shim.Error("Unknown wand status: " + status)
+	}
+	if len(args) == 3 {
+		return getWandsPage(stub, wandStatusIndex, []string{status}, args[1:])
+	}
+
+	// Query the wandStatus~ID index by status
+	wands, err := getWandsFromIndex(stub, wandStatusIndex, []string{status})
+	if err != nil {
+		return shim.Error("Failed to get wands: " + err.Error())
+	}
+
+	// Marshal the wands list to JSON
+	wandsJSON, err := json.Marshal(wands)
+	if err != nil {
+		return shim.Error("Failed to marshal wands to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query wands by status")
+	return shim.Success(wandsJSON)
+}
+
+// ===============================================
+// getWandByMaterial - returns the wand that consumed the given ID material, read from the material~wand index.
+// A material released by dismantleWand or repairWand returns the last wand it left.
+// ===============================================
+func (t *Studio) getWandByMaterial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query wand by material")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material to query")
+	}
+
+	materialID := args[0]
+	wands, err := getWandsFromIndex(stub, materialWandIndex, []string{materialID})
+	if err != nil {
+		return shim.Error("Failed to get wand: " + err.Error())
+	}
+	if len(wands) == 0 {
+		return shim.Error("Material " + materialID + " was not consumed by any wand")
+	}
+
+	// The index lists every wand the material was part of, by wand ID
+	wand := &wands[0]
+	if len(wands) > 1 {
+		material, err := getMaterial(stub, materialID)
+		if err != nil {
+			return shim.Error("Failed to get material details: " + err.Error())
+		} else if material == nil {
+			return shim.Error("Material does not exist: " + materialID)
+		}
+		lastWandID := material.ConsumedBy
+		if lastWandID == "" && len(material.Lineage) > 0 {
+			lastWandID = material.Lineage[len(material.Lineage)-1].WandID
+		}
+		for i := range wands {
+			if wands[i].ID == lastWandID {
+				wand = &wands[i]
+			}
+		}
+	}
+
+	wandJSON, err := json.Marshal(wand)
+	if err != nil {
+		return shim.Error("Failed to marshal wand to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query wand by material")
+	return shim.Success(wandJSON)
+}
+
+// ===============================================
+// getWandsBySupplier - returns all wands made with materials of the given supplier, read from the
+// supplier~wand index, so a bad supplier batch can be traced to the affected wands. Wands that
+// were dismantled or had the materials replaced are returned too.
+// ===============================================
+func (t *Studio) getWandsBySupplier(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start query wands by supplier")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting supplier of the materials to query")
+	}
+
+	indexKeys, err := getIndexKeys(stub, supplierWandIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error("Failed to get wands: " + err.Error())
+	}
+
+	// The index has one entry per material, a wand with several materials of the supplier is returned once
+	wands := []Wand{}
+	seen := make(map[string]bool)
+	for _, indexKey := range indexKeys {
+		_, compositeKeyParts, err := stub.SplitCompositeKey(indexKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		wandID := compositeKeyParts[1]
+		if seen[wandID] {
+			continue
+		}
+		seen[wandID] = true
+
+		wand, err := getWand(stub, wandID)
+		if err != nil {
+			return shim.Error("Failed to get wand: " + err.Error())
+		}
+		if wand == nil {
+			// Ignore index entries pointing to missing wands
+			continue
+		}
+		wands = append(wands, *wand)
+	}
+
+	wandsJSON, err := json.Marshal(wands)
+	if err != nil {
+		return shim.Error("Failed to marshal wands to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end query wands by supplier")
+	return shim.Success(wandsJSON)
+}
+
+// ===============================================
+// readWandSalePrivateDetails - read the sale price of the given ID wand.
+// Only members of a collection holding the price can read it, through a peer of their own organization.
+// ===============================================
+func (t *Studio) readWandSalePrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start read wand sale private details")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand to query")
+	}
+
+	wandID := args[0]
+	wand, err := getWand(stub, wandID)
+	if err != nil {
+		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
+	} else if wand == nil {
+		return shim.Error("Wand does not exist: " + wandID)
+	} else if wand.Sale == nil {
+		return shim.Error("Wand " + wandID + " was not sold yet")
+	}
+
+	// Checks the caller organization is a member of a collection holding the price
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Error getting MSP ID: " + err.Error())
+	}
+	collection := implicitCollectionPrefix + mspid
+	if !contains(wand.Sale.PrivateDetailsCollections, collection) {
+		return unauthorized("unauthorized: organization " + mspid + " is not a member of the collections holding the sale private details of " + wandID)
+	}
+
+	// The peer only holds the implicit collection of its own organization
+	peerMSPID, err := shim.GetMSPID()
+	if err != nil {
+		return shim.Error("Error getting peer MSP ID: " + err.Error())
+	}
+	if peerMSPID != mspid {
+		return unauthorized("unauthorized: private details of organization " + mspid + " must be read through one of its peers")
+	}
+
+	privateDetailsKey, err := stub.CreateCompositeKey(wandSalePrivateDetailsObjectType, []string{wandID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	privateDetailsBytes, err := stub.GetPrivateData(collection, privateDetailsKey)
+	if err != nil {
+		return shim.Error("Failed to get sale private details for " + wandID + ": " + err.Error())
+	} else if privateDetailsBytes == nil {
+		return shim.Error("Wand sale private details do not exist: " + wandID)
+	}
+
+	fmt.Println("- end read wand sale private details")
+	return shim.Success(privateDetailsBytes)
+}
+
+// ===============================================
+// getWandsFromIndex - returns the wands whose index entries match the given attributes.
+// The wand ID must be the last attribute of the index.
+// ===============================================
+func getWandsFromIndex(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]Wand, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return getWandsFromIterator(stub, resultsIterator)
+}
+
+// ===============================================
+// getWandsPage - returns one page of the wands whose index entries match the given attributes.
+// paginationArgs holds the page size and the bookmark returned with the previous page.
+// ===============================================
+func getWandsPage(stub shim.ChaincodeStubInterface, indexName string, attributes []string, paginationArgs []string) pb.Response {
+	pageSize, bookmark, err := parsePagination(paginationArgs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, responseMetadata, err := getIndexPage(stub, indexName, attributes, pageSize, bookmark)
+	if err != nil {
+		return shim.Error("Failed to get wands: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	wands, err := getWandsFromIterator(stub, resultsIterator)
+	if err != nil {
+		return shim.Error("Failed to get wands: " + err.Error())
+	}
+	if wands == nil {
+		wands = []Wand{}
+	}
+
+	pageJSON, err := json.Marshal(newPage(wands, len(wands), responseMetadata))
+	if err != nil {
+		return shim.Error("Failed to marshal wands to JSON: " + err.Error())
+	}
+	return shim.Success(pageJSON)
+}
+
+// ===============================================
+// getWandsFromIterator - returns the wands of the index entries returned by the iterator.
+// The wand ID must be the last attribute of the index.
+// ===============================================
+func getWandsFromIterator(stub shim.ChaincodeStubInterface, resultsIterator shim.StateQueryIteratorInterface) ([]Wand, error) {
+	var wands []Wand
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// get the ID from the last attribute of the composite key
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		wandID := compositeKeyParts[len(compositeKeyParts)-1]
+
+		wand, err := getWand(stub, wandID)
+		if err != nil {
+			return nil, err
+		}
+		if wand == nil {
+			// Ignore index entries pointing to missing wands
+			continue
+		}
+		wands = append(wands, *wand)
+	}
+
+	return wands, nil
+}
+
+// ===============================================
+// getWand - reads the ID given wand from its Wand~ID key.
+// Returns nil if it does not exist and an error if the key holds another docType.
+// ===============================================
+func getWand(stub shim.ChaincodeStubInterface, wandID string) (*Wand, error) {
+	wandKey, err := stub.CreateCompositeKey(wandObjectType, []string{wandID})
+	if err != nil {
+		return nil, err
+	}
+
+	wandBytes, err := stub.GetState(wandKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wand %s: %s", wandID, err)
+	}
+	if wandBytes == nil {
+		return nil, nil
+	}
+
+	var wand Wand
+	err = json.Unmarshal(wandBytes, &wand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal wand %s: %s", wandID, err)
+	}
+	if wand.ObjectType != wandObjectType {
+		return nil, fmt.Errorf("document %s is a %s, not a %s", wandID, wand.ObjectType, wandObjectType)
+	}
+
+	return &wand, nil
+}
+
+// ===============================================
+// putWand - saves the wand under its Wand~ID key, bumping its version, and keeps its index
+// entries in sync. previous is the wand as currently saved in state, nil for a new or migrated wand.
+// created is only set by initWand, when the wand is created by the transaction.
+// ===============================================
+func putWand(stub shim.ChaincodeStubInterface, wand *Wand, previous *Wand, created bool) error {
+	wandKey, err := stub.CreateCompositeKey(wandObjectType, []string{wand.ID})
+	if err != nil {
+		return err
+	}
+
+	if err = stampMetadata(stub, &wand.Metadata, created); err != nil {
+		return err
+	}
+	wand.Version++
+
+	wandJSONasBytes, err := json.Marshal(wand)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(wandKey, wandJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	// Old index entries are deleted before the new ones are saved, see putMaterial
+	if previous != nil {
+		previousIndexKeys, err := wandIndexKeys(stub, previous)
+		if err != nil {
+			return err
+		}
+		for _, indexKey := range previousIndexKeys {
+			if err = stub.DelState(indexKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	indexKeys, err := wandIndexKeys(stub, wand)
+	if err != nil {
+		return err
+	}
+	for _, indexKey := range indexKeys {
+		if err = stub.PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ===============================================
+// delWand - removes the wand and its index entries from state
+// ===============================================
+func delWand(stub shim.ChaincodeStubInterface, wand *Wand) error {
+	wandKey, err := stub.CreateCompositeKey(wandObjectType, []string{wand.ID})
+	if err != nil {
+		return err
+	}
+	if err = stub.DelState(wandKey); err != nil {
+		return err
+	}
+
+	indexKeys, err := wandIndexKeys(stub, wand)
+	if err != nil {
+		return err
+	}
+	for _, indexKey := range indexKeys {
+		if err = stub.DelState(indexKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ===============================================
+// getWandSalePrivateDetailsFromTransient - returns the sale private details passed in the transient map
+// for the given wand ID, nil if there are none
+// ===============================================
+func getWandSalePrivateDetailsFromTransient(stub shim.ChaincodeStubInterface, wandID string) (*WandSalePrivateDetails, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %s", err)
+	}
+
+	privateDetailsBytes, ok := transientMap[wandSalePrivateDetailsTransientKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var privateDetails WandSalePrivateDetails
+	err = json.Unmarshal(privateDetailsBytes, &privateDetails)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON of %s: %s", wandSalePrivateDetailsTransientKey, err)
+	}
+	if privateDetails.Price < 0 {
+		return nil, fmt.Errorf("price cannot be negative")
+	}
+
+	// The private details always belong to the given wand
+	privateDetails.ObjectType = wandSalePrivateDetailsObjectType
+	privateDetails.ID = wandID
+
+	return &privateDetails, nil
+}
+
+// ===============================================
+// putWandSalePrivateDetails - saves the sale private details in the given collection
+// ===============================================
+func putWandSalePrivateDetails(stub shim.ChaincodeStubInterface, collection string, privateDetails *WandSalePrivateDetails) error {
+	privateDetailsKey, err := stub.CreateCompositeKey(wandSalePrivateDetailsObjectType, []string{privateDetails.ID})
+	if err != nil {
+		return err
+	}
+
+	privateDetailsBytes, err := json.Marshal(privateDetails)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutPrivateData(collection, privateDetailsKey, privateDetailsBytes)
+}
+
+// ===============================================
+// wandIndexKeys - returns the index entries of the wand
+// ===============================================
+func wandIndexKeys(stub shim.ChaincodeStubInterface, wand *Wand) ([]string, error) {
+	// Deleted wands are hidden from every index
+	if wand.Deleted != nil {
+		return nil, nil
+	}
+
+	typeIndexKey, err := stub.CreateCompositeKey(wandTypeIndex, []string{wand.Type, wand.ID})
+	if err != nil {
+		return nil, err
+	}
+	statusIndexKey, err := stub.CreateCompositeKey(wandStatusIndex, []string{wand.Status, wand.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{typeIndexKey, statusIndexKey}, nil
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções de eventos
+
+// ===============================================
+// setEvent - sets the chaincode event of the transaction, named after eventType
+// ===============================================
+func setEvent(stub shim.ChaincodeStubInterface, eventType string, records ...EventRecord) error {
+	timestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	if records == nil {
+		records = []EventRecord{}
+	}
+	event := Event{
+		Version:   eventSchemaVersion,
+		Type:      eventType,
+		TxID:      stub.GetTxID(),
+		Timestamp: timestamp,
+		Records:   records,
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %s", err)
+	}
+
+	return stub.SetEvent(eventType, eventJSON)
+}
+
+// newEventRecord - returns the event record of a changed document
+func newEventRecord(recordType string, docType string, ID string, document interface{}) (EventRecord, error) {
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return EventRecord{}, fmt.Errorf("failed to marshal %s %s: %s", docType, ID, err)
+	}
+
+	return EventRecord{Type: recordType, DocType: docType, ID: ID, Document: documentJSON}, nil
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções de atualização
+
+// ===============================================
+// applyPatch - applies the partial JSON patch to the document, a pointer to a Material or Wand.
+// Every field of the patch must be one of mutableFields. Returns the fields whose value changed.
+// ===============================================
+func applyPatch(document interface{}, patch string, mutableFields []string) ([]FieldChange, error) {
+	var patchFields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(patch), &patchFields); err != nil {
+		return nil, fmt.Errorf("patch must be a JSON object: %s", err)
+	}
+	if len(patchFields) == 0 {
+		return nil, fmt.Errorf("patch has no fields")
+	}
+
+	fieldNames := make([]string, 0, len(patchFields))
+	for field := range patchFields {
+		if !contains(mutableFields, field) {
+			return nil, fmt.Errorf("field %s cannot be updated", field)
+		}
+		fieldNames = append(fieldNames, field)
+	}
+	sort.Strings(fieldNames)
+
+	before, err := documentFields(document)
+	if err != nil {
+		return nil, err
+	}
+
+	// Patched fields are replaced as a whole. Unmarshal merges maps such as attributes into the existing
+	// value, so the fields are set to null first, which clears maps and slices.
+	nullFields := make(map[string]interface{}, len(fieldNames))
+	for _, field := range fieldNames {
+		nullFields[field] = nil
+	}
+	nullJSON, err := json.Marshal(nullFields)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(nullJSON, document); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(patch), document); err != nil {
+		return nil, err
+	}
+	after, err := documentFields(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FieldChange
+	for _, field := range fieldNames {
+		if !bytes.Equal(before[field], after[field]) {
+			changes = append(changes, FieldChange{Field: field, Old: before[field], New: after[field]})
+		}
+	}
+
+	return changes, nil
+}
+
+// copyAttributes returns a copy of the attributes of a material, so a previous version does not share them
+func copyAttributes(attributes map[string]string) map[string]string {
+	if attributes == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(attributes))
+	for name, value := range attributes {
+		copied[name] = value
+	}
+	return copied
+}
+
+// documentFields returns the JSON value of each field of the document
+func documentFields(document interface{}) (map[string]json.RawMessage, error) {
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(documentJSON, &fields)
+	return fields, err
+}
+
+// newChange - returns the change record of the transaction, producing the given version
+func newChange(stub shim.ChaincodeStubInterface, version int, fields []FieldChange) (Change, error) {
+	changedBy, err := getCallerIdentity(stub)
+	if err != nil {
+		return Change{}, fmt.Errorf("error getting caller identity: %s", err)
+	}
+	timestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return Change{}, err
+	}
+
+	return Change{Version: version, TxID: stub.GetTxID(), Timestamp: timestamp, ChangedBy: changedBy, Fields: fields}, nil
+}
+
+// ===============================================
+// newTombstone - returns the tombstone of a document deleted by the current transaction.
+// wandID is the wand a material is deleted with, empty for documents deleted on their own.
+// ===============================================
+func newTombstone(stub shim.ChaincodeStubInterface, reason string, wandID string) (*Tombstone, error) {
+	deletedBy, err := getCallerIdentity(stub)
+	if err != nil {
+		return nil, fmt.Errorf("error getting caller identity: %s", err)
+	}
+	deletedAt, err := getTxTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tombstone{DeletedAt: deletedAt, DeletedBy: deletedBy, Reason: reason, TxID: stub.GetTxID(), WandID: wandID}, nil
+}
+
+// ===============================================
+// stampMetadata - stamps the metadata of a document saved by the current transaction.
+// The created fields are only stamped when the document is created by the transaction. Documents saved
+// by older versions of the chaincode, including those moved by migrateLedger, keep them empty.
+// ===============================================
+func stampMetadata(stub shim.ChaincodeStubInterface, metadata *Metadata, created bool) error {
+	identity, err := getCallerIdentity(stub)
+	if err != nil {
+		return fmt.Errorf("error getting caller identity: %s", err)
+	}
+	timestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		metadata.CreatedAt = timestamp
+		metadata.CreatedTxID = stub.GetTxID()
+		metadata.CreatedBy = identity
+	}
+	metadata.UpdatedAt = timestamp
+	metadata.UpdatedTxID = stub.GetTxID()
+	metadata.UpdatedBy = identity
+
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções de esquema
+
+// schemaFiles holds the published JSON schemas of the document arguments, one file per function
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// ===============================================
+// getSchema - returns the JSON schema of the document accepted by the given function
+// ===============================================
+func (t *Studio) getSchema(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the function")
+	}
+
+	schemaBytes, err := schemaFiles.ReadFile("schemas/" + args[0] + ".json")
+	if err != nil {
+		return shim.Error("No schema for function: " + args[0])
+	}
+
+	return shim.Success(schemaBytes)
+}
+
+// ===============================================
+// validateDocument - validates the JSON document argument of the function against its schema.
+// Returns an error for every field that does not match.
+// ===============================================
+func validateDocument(function string, document string) ([]FieldError, error) {
+	schemaBytes, err := schemaFiles.ReadFile("schemas/" + function + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("no schema for function %s", function)
+	}
+	var schema jsonSchema
+	if err = json.Unmarshal(schemaBytes, &schema); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schema of %s: %s", function, err)
+	}
+
+	// Numbers are kept as json.Number so integers can be told apart from decimals
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(document)))
+	decoder.UseNumber()
+	if err = decoder.Decode(&value); err != nil {
+		return []FieldError{{Field: "", Error: "invalid JSON: " + err.Error()}}, nil
+	}
+
+	var fieldErrors []FieldError
+	err = validateValue(&schema, value, "", &fieldErrors)
+	return fieldErrors, err
+}
+
+// validateValue - validates a decoded JSON value against its schema, appending an error for every mismatch
+func validateValue(schema *jsonSchema, value interface{}, path string, fieldErrors *[]FieldError) error {
+	fail := func(msg string) {
+		*fieldErrors = append(*fieldErrors, FieldError{Field: path, Error: msg})
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return nil
+		}
+		for _, field := range schema.Required {
+			if _, ok := object[field]; !ok {
+				*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath(path, field), Error: "is required"})
+			}
+		}
+
+		// Other properties are refused when additionalProperties is false, and validated when it is a schema
+		var additional *jsonSchema
+		allowAdditional := true
+		if len(schema.AdditionalProperties) > 0 {
+			if err := json.Unmarshal(schema.AdditionalProperties, &allowAdditional); err != nil {
+				additional = &jsonSchema{}
+				if err = json.Unmarshal(schema.AdditionalProperties, additional); err != nil {
+					return fmt.Errorf("invalid additionalProperties in schema: %s", err)
+				}
+			}
+		}
+
+		fields := make([]string, 0, len(object))
+		for field := range object {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fieldSchema, ok := schema.Properties[field]
+			if !ok {
+				fieldSchema = additional
+			}
+			if fieldSchema == nil {
+				if !allowAdditional {
+					*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath(path, field), Error: "is not allowed"})
+				}
+				continue
+			}
+			if err := validateValue(fieldSchema, object[field], fieldPath(path, field), fieldErrors); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return nil
+		}
+		if schema.MinItems != nil && len(array) < *schema.MinItems {
+			fail(fmt.Sprintf("must have at least %d items", *schema.MinItems))
+		}
+		seen := make(map[string]bool)
+		for i, item := range array {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if schema.UniqueItems {
+				key := fmt.Sprint(item)
+				if seen[key] {
+					*fieldErrors = append(*fieldErrors, FieldError{Field: itemPath, Error: "repeats a previous item"})
+				}
+				seen[key] = true
+			}
+			if schema.Items != nil {
+				if err := validateValue(schema.Items, item, itemPath, fieldErrors); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return nil
+		}
+		if schema.MinLength != nil && len([]rune(text)) < *schema.MinLength {
+			if *schema.MinLength == 1 {
+				fail("cannot be empty")
+			} else {
+				fail(fmt.Sprintf("must have at least %d characters", *schema.MinLength))
+			}
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, text) {
+			fail(fmt.Sprintf("must be one of %v", schema.Enum))
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, text); err != nil {
+				fail("must be an RFC 3339 date-time")
+			}
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			fail("must be an integer")
+			return nil
+		}
+		integer, err := strconv.ParseInt(number.String(), 10, 64)
+		if err != nil {
+			fail("must be an integer")
+			return nil
+		}
+		if schema.Minimum != nil && float64(integer) < *schema.Minimum {
+			fail(fmt.Sprintf("must be at least %v", *schema.Minimum))
+		}
+	default:
+		return fmt.Errorf("unsupported schema type %s", schema.Type)
+	}
+
+	return nil
+}
+
+// fieldPath returns the path of a field inside the object at path
+func fieldPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// materialDocument is the JSON document accepted by initMaterial and, in an array, by initMaterialsBatch
+type materialDocument struct {
+	ID         string            `json:"ID"`
+	Type       string            `json:"type"`
+	Supplier   string            `json:"supplier"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// ===============================================
+// initMaterialArgsFromDocument - validates the initMaterial JSON document and returns it as positional arguments
+// ===============================================
+func initMaterialArgsFromDocument(document string) ([]string, []FieldError, error) {
+	fieldErrors, err := validateDocument("initMaterial", document)
+	if err != nil || len(fieldErrors) > 0 {
+		return nil, fieldErrors, err
+	}
+
+	var material materialDocument
+	if err = json.Unmarshal([]byte(document), &material); err != nil {
+		return nil, nil, err
+	}
+
+	args := []string{material.ID, material.Type, material.Supplier}
+	if material.Attributes != nil {
+		attributesJSON, err := json.Marshal(material.Attributes)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, string(attributesJSON))
+	}
+
+	return args, nil, nil
+}
+
+// ===============================================
+// initWandArgsFromDocument - validates the initWand JSON document and returns it as positional arguments
+// ===============================================
+func initWandArgsFromDocument(document string) ([]string, []FieldError, error) {
+	fieldErrors, err := validateDocument("initWand", document)
+	if err != nil || len(fieldErrors) > 0 {
+		return nil, fieldErrors, err
+	}
+
+	var wand struct {
+		ID        string   `json:"ID"`
+		Type      string   `json:"type"`
+		Color     string   `json:"color"`
+		Size      int      `json:"size"`
+		Materials []string `json:"materials"`
+	}
+	if err = json.Unmarshal([]byte(document), &wand); err != nil {
+		return nil, nil, err
+	}
+
+	args := []string{wand.ID, wand.Type, wand.Color, strconv.Itoa(wand.Size), strconv.Itoa(len(wand.Materials))}
+	return append(args, wand.Materials...), nil, nil
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções de histórico
+
+// ===============================================
+// getMaterialHistory - returns every version of the given ID material, including deletions
+// and the versions saved under its raw ID by older versions of the chaincode
+// ===============================================
+func (t *Studio) getMaterialHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start get material history")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the material to query")
+	}
+
+	historyJSON, err := getDocumentHistoryJSON(stub, materialObjectType, args[0])
+	if err != nil {
+		return shim.Error("Failed to get history of material " + args[0] + ": " + err.Error())
+	}
+
+	fmt.Println("- end get material history")
+	return shim.Success(historyJSON)
+}
+
+// ===============================================
+// getWandHistory - returns every version of the given ID wand, including deletions
+// and the versions saved under its raw ID by older versions of the chaincode
+// ===============================================
+func (t *Studio) getWandHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start get wand history")
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting ID of the wand to query")
+	}
+
+	historyJSON, err := getDocumentHistoryJSON(stub, wandObjectType, args[0])
+	if err != nil {
+		return shim.Error("Failed to get history of wand " + args[0] + ": " + err.Error())
+	}
+
+	fmt.Println("- end get wand history")
+	return shim.Success(historyJSON)
+}
+
+// ===============================================
+// getDocumentHistory - returns every version of the document, oldest first. Older versions of the
+// chaincode saved documents under their raw ID, that part of the history is read from the raw key.
+// ===============================================
+func getDocumentHistory(stub shim.ChaincodeStubInterface, objectType string, ID string) ([]HistoryEntry, error) {
+	documentKey, err := stub.CreateCompositeKey(objectType, []string{ID})
+	if err != nil {
+		return nil, err
+	}
+	history, err := getKeyHistory(stub, documentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyHistory, err := getKeyHistory(stub, ID)
+	if err != nil {
+		return nil, err
+	}
+	// The raw key may have held a document of another type, or one of the legacy index lists
+	isDocument := false
+	for _, entry := range legacyHistory {
+		var document struct {
+			ObjectType string `json:"docType"`
+		}
+		if !entry.IsDelete && json.Unmarshal(entry.Value, &document) == nil && document.ObjectType == objectType {
+			isDocument = true
+			break
+		}
+	}
+	if !isDocument {
+		return history, nil
+	}
+
+	// On equal timestamps the raw key comes first, the migration deleted it when it saved the namespaced key
+	merged := make([]HistoryEntry, 0, len(legacyHistory)+len(history))
+	i := 0
+	for _, entry := range history {
+		for i < len(legacyHistory) && !historyTime(legacyHistory[i]).After(historyTime(entry)) {
+			merged = append(merged, legacyHistory[i])
+			i++
+		}
+		merged = append(merged, entry)
+	}
+	return append(merged, legacyHistory[i:]...), nil
+}
+
+// historyTime returns the commit time of the history entry, the zero time if the peer did not return it
+func historyTime(entry HistoryEntry) time.Time {
+	timestamp, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return timestamp
+}
+
+// historyMoves returns the transactions that deleted and saved the document in the history,
+// which are the moves from the raw key to the namespaced key made by the migration
+func historyMoves(history []HistoryEntry) map[string]bool {
+	deleted := make(map[string]bool)
+	saved := make(map[string]bool)
+	for _, entry := range history {
+		if entry.IsDelete {
+			deleted[entry.TxID] = true
+		} else {
+			saved[entry.TxID] = true
+		}
+	}
+
+	moves := make(map[string]bool)
+	for txID := range deleted {
+		if saved[txID] {
+			moves[txID] = true
+		}
+	}
+	return moves
+}
+
+// ===============================================
+// getDocumentHistoryJSON - returns the history of the document marshaled to JSON, an empty list if it never existed
+// ===============================================
+func getDocumentHistoryJSON(stub shim.ChaincodeStubInterface, objectType string, ID string) ([]byte, error) {
+	history, err := getDocumentHistory(stub, objectType, ID)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		history = []HistoryEntry{}
+	}
+
+	return json.Marshal(history)
+}
+
+// ===============================================
+// getKeyHistory - returns every version of the key, oldest first
+// ===============================================
+func getKeyHistory(stub shim.ChaincodeStubInterface, key string) ([]HistoryEntry, error) {
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var history []HistoryEntry
+	var timestamps []time.Time
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := HistoryEntry{
+			TxID:     modification.TxId,
+			IsDelete: modification.IsDelete,
+		}
+		if !modification.IsDelete {
+			entry.Value = json.RawMessage(modification.Value)
+		}
+		var timestamp time.Time
+		if modification.Timestamp != nil {
+			timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+			entry.Timestamp = formatTimestamp(modification.Timestamp.Seconds, modification.Timestamp.Nanos)
+		}
+		history = append(history, entry)
+		timestamps = append(timestamps, timestamp)
+	}
+
+	// Newer peers return the newest version first, the history is sorted from the oldest one
+	sort.Stable(historyByTimestamp{history, timestamps})
+
+	return history, nil
+}
+
+// historyByTimestamp sorts history entries by their commit timestamps
+type historyByTimestamp struct {
+	entries    []HistoryEntry
+	timestamps []time.Time
+}
+
+func (h historyByTimestamp) Len() int           { return len(h.entries) }
+func (h historyByTimestamp) Less(i, j int) bool { return h.timestamps[i].Before(h.timestamps[j]) }
+func (h historyByTimestamp) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.timestamps[i], h.timestamps[j] = h.timestamps[j], h.timestamps[i]
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções de índice
+
+// Page is the response of the paginated queries
+type Page struct {
+	Records             interface{} `json:"records"`
+	FetchedRecordsCount int32       `json:"fetchedRecordsCount"`
+	Bookmark            string      `json:"bookmark"` // pass it to get the next page, empty after the last one
+}
+
+// newPage returns the page of the given records. The count is the number of records returned, which is lower
+// than the count of the query metadata when index entries point to missing documents.
+func newPage(records interface{}, count int, responseMetadata *pb.QueryResponseMetadata) Page {
+	page := Page{Records: records, FetchedRecordsCount: int32(count)}
+	if responseMetadata != nil {
+		page.Bookmark = responseMetadata.Bookmark
+	}
+	return page
+}
+
+// ===============================================
+// parsePagination - parses the page size and bookmark arguments of the paginated queries
+// ===============================================
+func parsePagination(args []string) (int32, string, error) {
+	if len(args) != 2 {
+		return 0, "", fmt.Errorf("incorrect number of pagination arguments. Expecting page size and bookmark")
+	}
+
+	pageSize, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil || pageSize <= 0 {
+		return 0, "", fmt.Errorf("page size must be a positive integer")
+	}
+
+	return int32(pageSize), args[1], nil
+}
+
+// ===============================================
+// getIndexPage - returns one page of the entries of the given index matching the given attributes
+// ===============================================
+func getIndexPage(stub shim.ChaincodeStubInterface, indexName string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	resultsIterator, responseMetadata, err := stub.GetStateByPartialCompositeKeyWithPagination(indexName, attributes, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Stubs without paginated queries, such as the shimtest mock, return no iterator
+	if resultsIterator == nil {
+		return nil, nil, fmt.Errorf("paginated queries are not supported")
+	}
+	return resultsIterator, responseMetadata, nil
+}
+
+// ===============================================
+// getIndexKeys - returns the composite keys of the given index matching the given attributes
+// ===============================================
+func getIndexKeys(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var keys []string
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, responseRange.Key)
+	}
+
+	return keys, nil
+}
+
+// ===============================================
+// countIndexKeys - returns the number of composite keys of the given index matching the given attributes
+// ===============================================
+func countIndexKeys(stub shim.ChaincodeStubInterface, indexName string, attributes []string) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		if _, err := resultsIterator.Next(); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// ===============================================
+// migrateLedger - moves ledgers written by older versions of the chaincode to the
+// current layout: Material~ID / Wand~ID document keys and composite key indexes
+// ===============================================
+func (t *Studio) migrateLedger(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start migrate ledger")
+
+	migrated, err := migrateLegacyLedger(stub)
+	if err != nil {
+		return shim.Error("Failed to migrate ledger: " + err.Error())
+	}
+
+	migratedJSON, err := json.Marshal(migrated)
+	if err != nil {
+		return shim.Error("Failed to marshal migration result to JSON: " + err.Error())
+	}
+
+	// Listeners should rebuild their projections from the migrated state
+	err = setEvent(stub, eventLedgerMigrated)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end migrate ledger")
+	return shim.Success(migratedJSON)
+}
+
+// ===============================================
+// migrateLegacyLedger - moves the documents saved under their raw ID to their namespaced keys and
+// rebuilds the indexes from the legacy JSON index lists and type~ID keys, deleting them afterwards.
+// Returns the number of migrated documents and index entries, it does nothing on a migrated ledger.
+// ===============================================
+func migrateLegacyLedger(stub shim.ChaincodeStubInterface) (map[string]int, error) {
+	migrated := map[string]int{"num_materials": 0, "num_wands": 0, "num_available_materials": 0}
+
+	// ==== Find the available materials ====
+	// The materialIndexList is the source of truth: the old initWand removed consumed materials
+	// from the list but left their type~ID keys behind
+	available := make(map[string]bool)
+	materialIndexListBytes, err := stub.GetState(legacyMaterialIndexListKey)
+	if err != nil {
+		return nil, err
+	}
+	if materialIndexListBytes != nil {
+		var materialIndexList []string
+		if err = json.Unmarshal(materialIndexListBytes, &materialIndexList); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal material index list: %s", err)
+		}
+		for _, compositeKey := range materialIndexList {
+			_, compositeKeyParts, err := stub.SplitCompositeKey(compositeKey)
+			if err != nil {
+				return nil, err
+			}
+			available[compositeKeyParts[1]] = true
+		}
+		if err = stub.DelState(legacyMaterialIndexListKey); err != nil {
+			return nil, err
+		}
+	}
+
+	legacyTypeKeys, err := getIndexKeys(stub, legacyTypeIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	for _, compositeKey := range legacyTypeKeys {
+		if materialIndexListBytes == nil {
+			_, compositeKeyParts, err := stub.SplitCompositeKey(compositeKey)
+			if err != nil {
+				return nil, err
+			}
+			available[compositeKeyParts[1]] = true
+		}
+		if err = stub.DelState(compositeKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// Wands were listed with type~ID keys that were never saved, they are indexed again from their documents
+	wandsIndexListBytes, err := stub.GetState(legacyWandsIndexListKey)
+	if err != nil {
+		return nil, err
+	}
+	if wandsIndexListBytes != nil {
+		if err = stub.DelState(legacyWandsIndexListKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// ==== Move the documents saved under their raw ID ====
+	var legacyMaterials []Material
+	var legacyWands []Wand
+	var legacyKeys []string
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error("Failed to split composite key: " + err.Error())
+			return nil, err
+		}
+
+		// Skip values that are not documents, such as the legacy index lists
+		var document struct {
+			ObjectType string `json:"docType"`
+			ID         string `json:"ID"`
+		}
+		if json.Unmarshal(responseRange.Value, &document) != nil {
+			continue
+		}
+		if document.ObjectType != materialObjectType && document.ObjectType != wandObjectType {
+			continue
+		}
+
+		documentKey, err := stub.CreateCompositeKey(document.ObjectType, []string{document.ID})
+		if err != nil {
+			return nil, err
+		}
+		if documentKey == responseRange.Key {
+			// Already saved under its namespaced key
+			continue
+		}
+
+		if document.ObjectType == materialObjectType {
+			var material Material
+			if err = json.Unmarshal(responseRange.Value, &material); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal material %s: %s", responseRange.Key, err)
+			}
+			legacyMaterials = append(legacyMaterials, material)
+		} else {
+			var wand Wand
+			if err = json.Unmarshal(responseRange.Value, &wand); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal wand %s: %s", responseRange.Key, err)
+			}
+			legacyWands = append(legacyWands, wand)
+		}
+		legacyKeys = append(legacyKeys, responseRange.Key)
+	}
+
+	// Materials that left the available list were consumed by a wand
+	consumedBy := make(map[string]string)
+	for i := range legacyWands {
+		for _, materialID := range legacyWands[i].Materials {
+			consumedBy[materialID] = legacyWands[i].ID
+		}
+		legacyWands[i].Status = wandStatusInStock
+		if err = putWand(stub, &legacyWands[i], nil, false); err != nil {
+			return nil, err
+		}
+		migrated["num_wands"]++
+	}
+
+	for i := range legacyMaterials {
+		material := &legacyMaterials[i]
+		if available[material.ID] {
+			material.Status = statusAvailable
+			migrated["num_available_materials"]++
+		} else {
+			material.Status = statusConsumed
+			material.ConsumedBy = consumedBy[material.ID]
+		}
+		if err = putMaterial(stub, material, nil, false); err != nil {
+			return nil, err
+		}
+		migrated["num_materials"]++
+	}
+
+	for _, legacyKey := range legacyKeys {
+		if err = stub.DelState(legacyKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return migrated, nil
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções de integridade
+
+// IntegrityReport is the response of checkIntegrity
+type IntegrityReport struct {
+	Materials    int `json:"materials"`    // material documents checked
+	Wands        int `json:"wands"`        // wand documents checked
+	IndexEntries int `json:"indexEntries"` // index entries checked
+
+	Orphans            []IndexEntry        `json:"orphans"`            // entries of missing or deleted documents, or that match no document
+	Duplicates         []IndexEntry        `json:"duplicates"`         // stale entries of documents that also have their right entry in the index
+	Missing            []IndexEntry        `json:"missing"`            // entries of documents that are not in state
+	DanglingReferences []DanglingReference `json:"danglingReferences"` // wands and materials that do not point to each other
+	LegacyKeys         []string            `json:"legacyKeys"`         // keys of older versions, removed by migrateLedger
+}
+
+// IndexEntry is an index composite key, split into the index name and its attributes
+type IndexEntry struct {
+	Index      string   `json:"index"`
+	Attributes []string `json:"attributes"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+// DanglingReference is a reference from a wand to a material, or back, that the other document does not confirm
+type DanglingReference struct {
+	DocType   string `json:"docType"`
+	ID        string `json:"ID"`
+	Field     string `json:"field"`
+	Reference string `json:"reference"`
+	Reason    string `json:"reason"`
+}
+
+// IndexRepair is the response of repairIndexes
+type IndexRepair struct {
+	Removed []IndexEntry `json:"removed"`
+	Added   []IndexEntry `json:"added"`
+}
+
+// documentIndexes lists the indexes of materials and wands, with the position of the document ID in their attributes
+var documentIndexes = []struct {
+	name       string
+	docType    string
+	idPosition int
+}{
+	{materialTypeIndex, materialObjectType, 1},
+	{materialStatusIndex, materialObjectType, 1},
+	{materialWandIndex, materialObjectType, 0},
+	{supplierWandIndex, materialObjectType, 2},
+	{materialSupplierMonthIndex, materialObjectType, 3},
+	{materialTypeMonthIndex, materialObjectType, 3},
+	{materialMonthIndex, materialObjectType, 2},
+	{wandTypeIndex, wandObjectType, 1},
+	{wandStatusIndex, wandObjectType, 1},
+}
+
+// ===============================================
+// checkIntegrity - cross-checks the material and wand documents against their index entries and
+// against each other, reporting orphan, duplicate and missing entries and dangling material references
+// ===============================================
+func (t *Studio) checkIntegrity(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start check integrity")
+
+	report, err := checkLedgerIntegrity(stub)
+	if err != nil {
+		return shim.Error("Failed to check integrity: " + err.Error())
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error("Failed to marshal integrity report to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end check integrity")
+	return shim.Success(reportJSON)
+}
+
+// ===============================================
+// repairIndexes - rebuilds the material and wand indexes from the documents, deleting the orphan and
+// duplicate entries and saving the missing ones. Dangling references are left for the caller to fix.
+// ===============================================
+func (t *Studio) repairIndexes(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start repair indexes")
+
+	report, err := checkLedgerIntegrity(stub)
+	if err != nil {
+		return shim.Error("Failed to check integrity: " + err.Error())
+	}
+
+	// The legacy keys are moved by the migration, which reads and writes the same keys
+	if len(report.LegacyKeys) > 0 {
+		return shim.Error("Ledger has keys of older versions, call migrateLedger before repairIndexes")
+	}
+
+	repair := IndexRepair{Removed: append(report.Orphans, report.Duplicates...), Added: report.Missing}
+	for _, entry := range repair.Removed {
+		indexKey, err := stub.CreateCompositeKey(entry.Index, entry.Attributes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err = stub.DelState(indexKey); err != nil {
+			return shim.Error("Failed to delete index entry: " + err.Error())
 		}
-		wandID := composite_parts[1]
-		wandBytes, err := stub.GetState(wandID)
+	}
+	for _, entry := range repair.Added {
+		indexKey, err := stub.CreateCompositeKey(entry.Index, entry.Attributes)
 		if err != nil {
-			return shim.Error("Failed to get wand details: " + err.Error())
+			return shim.Error(err.Error())
 		}
-		if wandBytes == nil {
-			return shim.Error("wand not found: " + wandID)
+		if err = stub.PutState(indexKey, []byte{0x00}); err != nil {
+			return shim.Error("Failed to save index entry: " + err.Error())
+		}
+	}
+
+	repairJSON, err := json.Marshal(repair)
+	if err != nil {
+		return shim.Error("Failed to marshal index repair to JSON: " + err.Error())
+	}
+
+	// Listeners keeping projections of the indexes should reload them
+	err = setEvent(stub, eventIndexesRepaired)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end repair indexes")
+	return shim.Success(repairJSON)
+}
+
+// ===============================================
+// checkLedgerIntegrity - reads every material and wand document and every entry of their indexes.
+// Documents and entries are read in key order, so the report is the same on every peer.
+// ===============================================
+func checkLedgerIntegrity(stub shim.ChaincodeStubInterface) (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		Orphans:            []IndexEntry{},
+		Duplicates:         []IndexEntry{},
+		Missing:            []IndexEntry{},
+		DanglingReferences: []DanglingReference{},
+		LegacyKeys:         []string{},
+	}
+
+	// ==== Read the documents ====
+	var materials []*Material
+	materialsByID := make(map[string]*Material)
+	err := forEachDocument(stub, materialObjectType, func(value []byte) error {
+		var material Material
+		if err := json.Unmarshal(value, &material); err != nil {
+			return err
 		}
+		materials = append(materials, &material)
+		materialsByID[material.ID] = &material
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read materials: %s", err)
+	}
 
-		// Unmarshal the wand details
+	var wands []*Wand
+	wandsByID := make(map[string]*Wand)
+	err = forEachDocument(stub, wandObjectType, func(value []byte) error {
 		var wand Wand
-		err = json.Unmarshal(wandBytes, &wand)
+		if err := json.Unmarshal(value, &wand); err != nil {
+			return err
+		}
+		wands = append(wands, &wand)
+		wandsByID[wand.ID] = &wand
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wands: %s", err)
+	}
+	report.Materials = len(materials)
+	report.Wands = len(wands)
+
+	// ==== Compute the entries each document should have ====
+	var expectedKeys []string
+	expected := make(map[string]bool)
+	for _, material := range materials {
+		indexKeys, err := materialIndexKeys(stub, material)
+		if err != nil {
+			return nil, err
+		}
+		expectedKeys = append(expectedKeys, indexKeys...)
+	}
+	for _, wand := range wands {
+		indexKeys, err := wandIndexKeys(stub, wand)
+		if err != nil {
+			return nil, err
+		}
+		expectedKeys = append(expectedKeys, indexKeys...)
+	}
+	for _, indexKey := range expectedKeys {
+		expected[indexKey] = true
+	}
+
+	// ==== Check the entries in state ====
+	present := make(map[string]bool)
+	for _, index := range documentIndexes {
+		indexKeys, err := getIndexKeys(stub, index.name, []string{})
 		if err != nil {
-			return shim.Error("Failed to unmarshal wand details: " + err.Error())
+			return nil, err
 		}
+		report.IndexEntries += len(indexKeys)
 
-		// Check if the wand type matches the requested type
-		if wand.Type == typeIndex {
-			// Increment the number of wands
-			num_wands++
+		var unexpected []IndexEntry
+		for _, indexKey := range indexKeys {
+			present[indexKey] = true
+			if expected[indexKey] {
+				continue
+			}
+			_, attributes, err := stub.SplitCompositeKey(indexKey)
+			if err != nil {
+				return nil, err
+			}
+			unexpected = append(unexpected, IndexEntry{Index: index.name, Attributes: attributes})
+		}
+
+		// An entry nobody expects is a duplicate when its document is in the index under its right entry
+		for _, entry := range unexpected {
+			if len(entry.Attributes) <= index.idPosition {
+				entry.Reason = "malformed entry"
+				report.Orphans = append(report.Orphans, entry)
+				continue
+			}
+			documentID := entry.Attributes[index.idPosition]
+
+			var deleted, found bool
+			var documentKeys []string
+			if index.docType == materialObjectType {
+				material, ok := materialsByID[documentID]
+				if ok {
+					found, deleted = true, material.Deleted != nil
+					documentKeys, err = materialIndexKeys(stub, material)
+				}
+			} else {
+				wand, ok := wandsByID[documentID]
+				if ok {
+					found, deleted = true, wand.Deleted != nil
+					documentKeys, err = wandIndexKeys(stub, wand)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			switch {
+			case !found:
+				entry.Reason = index.docType + " " + documentID + " does not exist"
+				report.Orphans = append(report.Orphans, entry)
+			case deleted:
+				entry.Reason = index.docType + " " + documentID + " is deleted"
+				report.Orphans = append(report.Orphans, entry)
+			case hasIndexEntry(stub, documentKeys, index.name, present):
+				entry.Reason = index.docType + " " + documentID + " already has its entry in the index"
+				report.Duplicates = append(report.Duplicates, entry)
+			default:
+				entry.Reason = "entry does not match " + index.docType + " " + documentID
+				report.Orphans = append(report.Orphans, entry)
+			}
 		}
 	}
 
-	// Marshal the number of wands to JSON
-	numWandsJSON, err := json.Marshal(map[string]int{"num_wands": num_wands})
+	for _, indexKey := range expectedKeys {
+		if present[indexKey] {
+			continue
+		}
+		indexName, attributes, err := stub.SplitCompositeKey(indexKey)
+		if err != nil {
+			return nil, err
+		}
+		report.Missing = append(report.Missing, IndexEntry{Index: indexName, Attributes: attributes})
+	}
+
+	// ==== Check the references between wands and materials ====
+	for _, wand := range wands {
+		for _, materialID := range wand.Materials {
+			material, ok := materialsByID[materialID]
+			reason := ""
+			switch {
+			case !ok:
+				reason = "material does not exist"
+			case wand.Status == wandStatusDismantled:
+				// The materials of a dismantled wand were released or destroyed
+			case material.ConsumedBy != wand.ID:
+				reason = "material is not consumed by the wand"
+			}
+			if reason != "" {
+				report.DanglingReferences = append(report.DanglingReferences, DanglingReference{DocType: wandObjectType, ID: wand.ID, Field: "Materials", Reference: materialID, Reason: reason})
+			}
+		}
+	}
+	for _, material := range materials {
+		if material.ConsumedBy == "" {
+			continue
+		}
+		wand, ok := wandsByID[material.ConsumedBy]
+		reason := ""
+		if !ok {
+			reason = "wand does not exist"
+		} else if !contains(wand.Materials, material.ID) {
+			reason = "wand does not list the material"
+		}
+		if reason != "" {
+			report.DanglingReferences = append(report.DanglingReferences, DanglingReference{DocType: materialObjectType, ID: material.ID, Field: "consumedBy", Reference: material.ConsumedBy, Reason: reason})
+		}
+	}
+
+	// ==== Check the keys left by older versions ====
+	for _, legacyKey := range []string{legacyMaterialIndexListKey, legacyWandsIndexListKey} {
+		legacyBytes, err := stub.GetState(legacyKey)
+		if err != nil {
+			return nil, err
+		}
+		if legacyBytes != nil {
+			report.LegacyKeys = append(report.LegacyKeys, legacyKey)
+		}
+	}
+	numLegacyTypeKeys, err := countIndexKeys(stub, legacyTypeIndex, []string{})
 	if err != nil {
-		return shim.Error("Failed to marshal number of wands to JSON: " + err.Error())
+		return nil, err
+	}
+	if numLegacyTypeKeys > 0 {
+		report.LegacyKeys = append(report.LegacyKeys, legacyTypeIndex)
 	}
 
-	fmt.Println("- end query number of wands by type")
-	return shim.Success(numWandsJSON)
+	return report, nil
 }
 
 // ===============================================
-// getTotalNumberOfWandss- returns total number of avaible wands on wandIndexList
+// forEachDocument - calls fn with the value of every document of the given docType, in key order
 // ===============================================
-func (t *Studio) getTotalNumberOfWands(stub shim.ChaincodeStubInterface) pb.Response {
-	fmt.Println("- start query number of wands")
+func forEachDocument(stub shim.ChaincodeStubInterface, objectType string, fn func(value []byte) error) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
 
-	/*
-		// Checks caller permission -Only Sr. olivares (org0) should be able to query wand list data-
-		fmt.Println("Checking permissions")
-		mspid, err := cid.GetMSPID(stub)
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error("Error getting MSP ID: " + err.Error())
+			return err
 		}
-		fmt.Printf("- mspid: %s\n", mspid)
-		// Checks if the MSP ID matches the allowed organization (org0)
-		if mspid != "Org0MSP" {
-			return shim.Error("Only members of organization 0 (Sr. Orlivaras) can perform this function")
+		if err = fn(responseRange.Value); err != nil {
+			return fmt.Errorf("%s: %s", responseRange.Key, err)
 		}
-	*/
+	}
 
-	// Retrieve the wandsIndexList from the world state
-	fmt.Println("- Trying to get wandsIndexList")
-	wandsIndexListBytes, err := stub.GetState("wandsIndexList")
-	if err != nil {
-		return shim.Error("Failed to get wand index list: " + err.Error())
+	return nil
+}
+
+// ===============================================
+// hasIndexEntry - tells if one of the document index keys belongs to the given index and is present in state
+// ===============================================
+func hasIndexEntry(stub shim.ChaincodeStubInterface, documentKeys []string, indexName string, present map[string]bool) bool {
+	for _, indexKey := range documentKeys {
+		name, _, err := stub.SplitCompositeKey(indexKey)
+		if err == nil && name == indexName && present[indexKey] {
+			return true
+		}
 	}
+	return false
+}
 
-	var wandsIndexList []string
-	err = json.Unmarshal(wandsIndexListBytes, &wandsIndexList)
-	if err != nil {
-		return shim.Error("Failed to unmarshal wand index list: " + err.Error())
+//--------------------------------------------------------------------------------------------
+// Funções de consulta rica
+
+// richQueryFields lists the fields richQuery selectors can use, by docType
+var richQueryFields = map[string][]string{
+	materialObjectType: {"ID", "type", "supplier", "status", "consumedBy", "createdAt", "updatedAt"},
+	wandObjectType:     {"ID", "type", "color", "size", "status", "owner", "createdAt", "updatedAt"},
+}
+
+// mangoOperators are the Mango condition operators richQuery selectors can use, besides $and and $or
+var mangoOperators = []string{"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in", "$nin"}
+
+// ===============================================
+// richQuery - returns the materials or wands matching a Mango selector, or one page of them
+// when the page size and bookmark are passed. The selector can only use the fields of richQueryFields
+// and the operators of mangoOperators. Deleted documents are never returned.
+// On CouchDB the query runs in the state database, on LevelDB the selector is evaluated by the chaincode.
+// ===============================================
+func (t *Studio) richQuery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start rich query")
+
+	if len(args) != 2 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting docType (Material or Wand) and Mango selector, optionally followed by page size and bookmark")
 	}
 
-	// Count the number of wands in the index list
-	num_wands := len(wandsIndexList)
+	objectType := args[0]
+	fields, ok := richQueryFields[objectType]
+	if !ok {
+		return shim.Error("Rich queries only support the Material and Wand docTypes")
+	}
 
-	// Marshal the number of wands to JSON
-	numWandsJSON, err := json.Marshal(map[string]int{"num_wands": num_wands})
+	// Numbers are kept as json.Number, like in the documents they are compared with
+	var selector map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(args[1]))
+	decoder.UseNumber()
+	if err := decoder.Decode(&selector); err != nil || selector == nil {
+		return unprocessable("invalid selector", []FieldError{{Field: "", Error: "must be a JSON object"}})
+	}
+	var fieldErrors []FieldError
+	checkSelector(selector, fields, "", &fieldErrors)
+	if len(fieldErrors) > 0 {
+		return unprocessable("invalid selector", fieldErrors)
+	}
+
+	// The docType and the tombstone are always part of the query
+	conditions := []interface{}{
+		map[string]interface{}{"docType": objectType},
+		map[string]interface{}{"deleted": map[string]interface{}{"$exists": false}},
+	}
+	if len(selector) > 0 {
+		conditions = append(conditions, selector)
+	}
+	queryJSON, err := json.Marshal(map[string]interface{}{"selector": map[string]interface{}{"$and": conditions}})
 	if err != nil {
-		return shim.Error("Failed to marshal number of wands to JSON: " + err.Error())
+		return shim.Error("Failed to marshal query: " + err.Error())
 	}
 
-	fmt.Println("- end query number of wands")
-	return shim.Success(numWandsJSON)
+	var resultJSON []byte
+	if len(args) == 2 {
+		documents, err := getQueryResultDocuments(stub, string(queryJSON))
+		if err == errRichQueryUnsupported {
+			// LevelDB does not run rich queries
+			fmt.Println("- rich query not run by the state database, evaluating the selector")
+			documents, err = evaluateSelector(stub, objectType, selector)
+		}
+		if err != nil {
+			return shim.Error("Failed to query documents: " + err.Error())
+		}
+		resultJSON, err = json.Marshal(documents)
+		if err != nil {
+			return shim.Error("Failed to marshal documents to JSON: " + err.Error())
+		}
+	} else {
+		pageSize, bookmark, err := parsePagination(args[2:])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		page, err := getQueryResultPage(stub, string(queryJSON), pageSize, bookmark)
+		if err == errRichQueryUnsupported {
+			fmt.Println("- rich query not run by the state database, evaluating the selector")
+			page, err = evaluateSelectorPage(stub, objectType, selector, pageSize, bookmark)
+		}
+		if err != nil {
+			return shim.Error("Failed to query documents: " + err.Error())
+		}
+		resultJSON, err = json.Marshal(page)
+		if err != nil {
+			return shim.Error("Failed to marshal documents to JSON: " + err.Error())
+		}
+	}
+
+	fmt.Println("- end rich query")
+	return shim.Success(resultJSON)
 }
 
-// ==================================================
-// deleteWand - remove a wand key/value pair from state
-// ==================================================
-func (t *Studio) deletewand(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("- start delete Wand")
+// ===============================================
+// checkSelector - checks that the selector only uses the given fields and the allowed operators
+// ===============================================
+func checkSelector(selector map[string]interface{}, fields []string, path string, fieldErrors *[]FieldError) {
+	fail := func(field string, msg string) {
+		*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath(path, field), Error: msg})
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Wand ID must be passed.")
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := selector[key]
+		switch {
+		case key == "$and" || key == "$or":
+			selectors, ok := value.([]interface{})
+			if !ok || len(selectors) == 0 {
+				fail(key, "must be a non-empty array of selectors")
+				continue
+			}
+			for i, item := range selectors {
+				itemSelector, ok := item.(map[string]interface{})
+				if !ok {
+					fail(fmt.Sprintf("%s[%d]", key, i), "must be a selector")
+					continue
+				}
+				checkSelector(itemSelector, fields, fmt.Sprintf("%s[%d]", fieldPath(path, key), i), fieldErrors)
+			}
+		case strings.HasPrefix(key, "$"):
+			fail(key, "operator is not allowed")
+		case !contains(fields, key):
+			fail(key, "field cannot be queried")
+		default:
+			condition, ok := value.(map[string]interface{})
+			if !ok {
+				if !isScalar(value) {
+					fail(key, "must be a value or an object of operators")
+				}
+				continue
+			}
+			if len(condition) == 0 {
+				fail(key, "must have at least one operator")
+			}
+			operators := make([]string, 0, len(condition))
+			for operator := range condition {
+				operators = append(operators, operator)
+			}
+			sort.Strings(operators)
+			for _, operator := range operators {
+				operand := condition[operator]
+				switch {
+				case !contains(mangoOperators, operator):
+					fail(key+"."+operator, "operator is not allowed")
+				case operator == "$in" || operator == "$nin":
+					values, ok := operand.([]interface{})
+					if !ok {
+						fail(key+"."+operator, "must be an array of values")
+						continue
+					}
+					for _, value := range values {
+						if !isScalar(value) {
+							fail(key+"."+operator, "must be an array of values")
+							break
+						}
+					}
+				case !isScalar(operand):
+					fail(key+"."+operator, "must be a value")
+				}
+			}
+		}
 	}
+}
 
-	wandID := args[0]
+// errRichQueryUnsupported is returned by the rich query helpers when the state database does not run rich queries
+var errRichQueryUnsupported = errors.New("rich queries are not supported by the state database")
 
-	// Get the wand details from the world state
-	valAsbytes, err := stub.GetState(wandID)
-	if err != nil {
-		return shim.Error("Failed to get state for " + wandID + ": " + err.Error())
-	} else if valAsbytes == nil {
-		return shim.Error("Wand does not exist: " + wandID)
+// ===============================================
+// richQueryError - returns errRichQueryUnsupported for the errors of state databases without a query engine:
+// LevelDB, which fails with "... not supported for leveldb", and the shimtest mock, which fails with
+// "not implemented". Other errors, such as an invalid bookmark or a timeout of CouchDB, are returned as they are.
+// ===============================================
+func richQueryError(err error) error {
+	if strings.Contains(err.Error(), "not supported for leveldb") || err.Error() == "not implemented" {
+		return errRichQueryUnsupported
 	}
+	return err
+}
 
-	var wandToDelete Wand
-	err = json.Unmarshal(valAsbytes, &wandToDelete)
+// ===============================================
+// getQueryResultDocuments - runs the CouchDB query and returns the documents found
+// ===============================================
+func getQueryResultDocuments(stub shim.ChaincodeStubInterface, query string) ([]json.RawMessage, error) {
+	resultsIterator, err := stub.GetQueryResult(query)
 	if err != nil {
-		return shim.Error("Failed to decode JSON of: " + string(valAsbytes) + ": " + err.Error())
+		return nil, richQueryError(err)
 	}
+	if resultsIterator == nil {
+		return nil, errRichQueryUnsupported
+	}
+	defer resultsIterator.Close()
+
+	return getDocumentsFromIterator(resultsIterator)
+}
 
-	// Delete the wand from state
-	err = stub.DelState(wandID)
+// ===============================================
+// getQueryResultPage - runs the CouchDB query and returns one page of the documents found
+// ===============================================
+func getQueryResultPage(stub shim.ChaincodeStubInterface, query string, pageSize int32, bookmark string) (*Page, error) {
+	resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(query, pageSize, bookmark)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return nil, richQueryError(err)
 	}
+	// Stubs without a query engine, such as the shimtest mock, return no iterator
+	if resultsIterator == nil {
+		return nil, errRichQueryUnsupported
+	}
+	defer resultsIterator.Close()
 
-	// Delete the wand index from the index list
-	indexName := "type~ID"
-	typeIDIndexKey, err := stub.CreateCompositeKey(indexName, []string{wandToDelete.Type, wandToDelete.ID})
+	documents, err := getDocumentsFromIterator(resultsIterator)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
+	}
+
+	page := newPage(documents, len(documents), responseMetadata)
+	return &page, nil
+}
+
+// ===============================================
+// getDocumentsFromIterator - returns the values returned by the iterator
+// ===============================================
+func getDocumentsFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]json.RawMessage, error) {
+	documents := []json.RawMessage{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, json.RawMessage(responseRange.Value))
 	}
-	indexListBytes, err := stub.GetState("wandsIndexList")
+
+	return documents, nil
+}
+
+// ===============================================
+// evaluateSelector - returns the documents of the docType matching the selector, in ID order.
+// Used when the state database does not run rich queries, it reads every document of the docType.
+// ===============================================
+func evaluateSelector(stub shim.ChaincodeStubInterface, objectType string, selector map[string]interface{}) ([]json.RawMessage, error) {
+	documents := []json.RawMessage{}
+	err := forEachDocument(stub, objectType, func(value []byte) error {
+		var document map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return err
+		}
+		if _, deleted := document["deleted"]; deleted {
+			return nil
+		}
+		if matchSelector(selector, document) {
+			documents = append(documents, json.RawMessage(value))
+		}
+		return nil
+	})
 	if err != nil {
-		return shim.Error("Failed to get wand index list: " + err.Error())
+		return nil, err
 	}
 
-	var indexList []string
-	err = json.Unmarshal(indexListBytes, &indexList)
+	return documents, nil
+}
+
+// ===============================================
+// evaluateSelectorPage - returns one page of the documents matching the selector.
+// The bookmark is the ID of the last document of the previous page.
+// ===============================================
+func evaluateSelectorPage(stub shim.ChaincodeStubInterface, objectType string, selector map[string]interface{}, pageSize int32, bookmark string) (*Page, error) {
+	documents, err := evaluateSelector(stub, objectType, selector)
 	if err != nil {
-		return shim.Error("Failed to unmarshal index list: " + err.Error())
+		return nil, err
 	}
 
-	// Remove the wand ID from the index list
-	var updatedIndexList []string
-	for i, index := range indexList {
-		if index == typeIDIndexKey {
-			updatedIndexList = append(indexList[:i], indexList[i+1:]...)
+	var records []json.RawMessage
+	page := &Page{}
+	for _, document := range documents {
+		var header struct {
+			ID string `json:"ID"`
+		}
+		if err = json.Unmarshal(document, &header); err != nil {
+			return nil, err
+		}
+		// Documents are in ID order, the page starts after the bookmark
+		if bookmark != "" && header.ID <= bookmark {
+			continue
+		}
+		if len(records) == int(pageSize) {
 			break
 		}
+		records = append(records, document)
+		page.Bookmark = header.ID
+	}
+	if len(records) < int(pageSize) {
+		page.Bookmark = ""
+	}
+	if records == nil {
+		records = []json.RawMessage{}
+	}
+	page.Records = records
+	page.FetchedRecordsCount = int32(len(records))
+
+	return page, nil
+}
+
+// ===============================================
+// matchSelector - tells if the document matches the selector, checked by checkSelector
+// ===============================================
+func matchSelector(selector map[string]interface{}, document map[string]interface{}) bool {
+	for key, value := range selector {
+		switch key {
+		case "$and", "$or":
+			matched := 0
+			selectors := value.([]interface{})
+			for _, item := range selectors {
+				if matchSelector(item.(map[string]interface{}), document) {
+					matched++
+				}
+			}
+			if (key == "$and" && matched < len(selectors)) || (key == "$or" && matched == 0) {
+				return false
+			}
+		default:
+			// Like CouchDB, conditions on missing fields do not match
+			field, ok := document[key]
+			if !ok {
+				return false
+			}
+			condition, ok := value.(map[string]interface{})
+			if !ok {
+				condition = map[string]interface{}{"$eq": value}
+			}
+			for operator, operand := range condition {
+				if !matchCondition(operator, field, operand) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// ===============================================
+// matchCondition - tells if the field value matches the operator and its operand
+// ===============================================
+func matchCondition(operator string, field interface{}, operand interface{}) bool {
+	switch operator {
+	case "$eq":
+		return valuesEqual(field, operand)
+	case "$ne":
+		return !valuesEqual(field, operand)
+	case "$in", "$nin":
+		found := false
+		for _, value := range operand.([]interface{}) {
+			if valuesEqual(field, value) {
+				found = true
+				break
+			}
+		}
+		return found == (operator == "$in")
+	}
+
+	// Ordering operators only compare numbers with numbers and strings with strings
+	cmp, ok := compareValues(field, operand)
+	if !ok {
+		return false
+	}
+	switch operator {
+	case "$gt":
+		return cmp > 0
+	case "$gte":
+		return cmp >= 0
+	case "$lt":
+		return cmp < 0
+	case "$lte":
+		return cmp <= 0
+	}
+	return false
+}
+
+// ===============================================
+// valuesEqual - tells if two JSON scalar values are equal, numbers by value
+// ===============================================
+func valuesEqual(a interface{}, b interface{}) bool {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	return isScalar(a) && a == b
+}
+
+// ===============================================
+// compareValues - compares two numbers or two strings. ok is false for other values.
+// ===============================================
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
+
+// ===============================================
+// isScalar - tells if the decoded JSON value is a string, number, boolean or null
+// ===============================================
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, json.Number, bool, nil:
+		return true
 	}
+	return false
+}
+
+//--------------------------------------------------------------------------------------------
+// Funções de relatório
+
+// InventoryReport is the response of getInventoryReport. Deleted documents are not counted.
+type InventoryReport struct {
+	GeneratedAt string            `json:"generatedAt"` // timestamp of the transaction that read the documents
+	Materials   MaterialInventory `json:"materials"`
+	Wands       WandInventory     `json:"wands"`
+}
+
+// MaterialInventory counts the materials by lifecycle status, in total and by type and supplier
+type MaterialInventory struct {
+	Total      int                       `json:"total"`
+	ByStatus   map[string]int            `json:"byStatus"`
+	ByType     map[string]map[string]int `json:"byType"`     // type, then status
+	BySupplier map[string]map[string]int `json:"bySupplier"` // supplier, then status
+}
+
+// WandInventory counts the wands by status, in total and by type and color
+type WandInventory struct {
+	Total    int                       `json:"total"`
+	ByStatus map[string]int            `json:"byStatus"`
+	ByType   map[string]map[string]int `json:"byType"`  // type, then status
+	ByColor  map[string]map[string]int `json:"byColor"` // color, then status
+}
+
+// ===============================================
+// getInventoryReport - counts the materials and wands in a single pass over their documents, instead of
+// one index scan per counter. Materials are grouped by type and supplier, wands by type and color,
+// and every group is split by status.
+// ===============================================
+func (t *Studio) getInventoryReport(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("- start get inventory report")
 
-	// Marshal the updated index list
-	updatedIndexListBytes, err := json.Marshal(updatedIndexList)
+	generatedAt, err := getTxTimestamp(stub)
 	if err != nil {
-		return shim.Error("Failed to marshal updated index list: " + err.Error())
+		return shim.Error(err.Error())
+	}
+
+	report := InventoryReport{
+		GeneratedAt: generatedAt,
+		Materials: MaterialInventory{
+			ByStatus:   map[string]int{},
+			ByType:     map[string]map[string]int{},
+			BySupplier: map[string]map[string]int{},
+		},
+		Wands: WandInventory{
+			ByStatus: map[string]int{},
+			ByType:   map[string]map[string]int{},
+			ByColor:  map[string]map[string]int{},
+		},
 	}
 
-	// Update the index list in the world state
-	err = stub.PutState("wandsIndexList", updatedIndexListBytes)
+	err = forEachDocument(stub, materialObjectType, func(value []byte) error {
+		var material Material
+		if err := json.Unmarshal(value, &material); err != nil {
+			return err
+		}
+		if material.Deleted != nil {
+			return nil
+		}
+		report.Materials.Total++
+		report.Materials.ByStatus[material.Status]++
+		countByStatus(report.Materials.ByType, material.Type, material.Status)
+		countByStatus(report.Materials.BySupplier, material.Supplier, material.Status)
+		return nil
+	})
 	if err != nil {
-		return shim.Error("Failed to update index list: " + err.Error())
+		return shim.Error("Failed to read materials: " + err.Error())
 	}
 
-	// Deleting each material of Materials list from Worldstate
-	for _, materialID := range wandToDelete.Materials {
-		// Delete the material from state
-		err = stub.DelState(materialID)
-		if err != nil {
-			return shim.Error("Failed to delete state:" + err.Error())
+	err = forEachDocument(stub, wandObjectType, func(value []byte) error {
+		var wand Wand
+		if err := json.Unmarshal(value, &wand); err != nil {
+			return err
 		}
+		if wand.Deleted != nil {
+			return nil
+		}
+		report.Wands.Total++
+		report.Wands.ByStatus[wand.Status]++
+		countByStatus(report.Wands.ByType, wand.Type, wand.Status)
+		countByStatus(report.Wands.ByColor, wand.Color, wand.Status)
+		return nil
+	})
+	if err != nil {
+		return shim.Error("Failed to read wands: " + err.Error())
 	}
 
-	fmt.Println("- end delete Wand")
-	return shim.Success(nil)
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error("Failed to marshal inventory report to JSON: " + err.Error())
+	}
+
+	fmt.Println("- end get inventory report")
+	return shim.Success(reportJSON)
+}
+
+// ===============================================
+// countByStatus - adds one to the status count of the given group
+// ===============================================
+func countByStatus(groups map[string]map[string]int, group string, status string) {
+	if groups[group] == nil {
+		groups[group] = map[string]int{}
+	}
+	groups[group][status]++
 }
 
 func main() {
@@ -0,0 +1,940 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// newTestStub returns a mock stub of the Studio chaincode called by an admin of Sr. Olivaras' organization
+func newTestStub(t *testing.T) *shimtest.MockStub {
+	stub := shimtest.NewMockStub("Studio", &Studio{})
+	stub.Creator = newCreator(t, ollivanderMSPID, roleAdmin)
+	return stub
+}
+
+// newCreator returns a serialized identity of the given organization, with the organizational units as roles
+func newCreator(t *testing.T, mspID string, organizationalUnits ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "user", OrganizationalUnit: organizationalUnits},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+var testTxNumber int
+
+// mockInvoker runs the chaincode in a mocked transaction, shimtest.MockStub and historyStub implement it
+type mockInvoker interface {
+	MockInvoke(uuid string, args [][]byte) pb.Response
+}
+
+// invoke calls the chaincode function with the given arguments in a new transaction
+func invoke(stub mockInvoker, args ...string) pb.Response {
+	testTxNumber++
+	var byteArgs [][]byte
+	for _, arg := range args {
+		byteArgs = append(byteArgs, []byte(arg))
+	}
+	return stub.MockInvoke(lastTxID(), byteArgs)
+}
+
+// lastTxID returns the ID of the transaction of the last invoke
+func lastTxID() string {
+	return fmt.Sprintf("tx%d", testTxNumber)
+}
+
+// mustInvoke calls the chaincode function and fails the test when it does not succeed
+func mustInvoke(t *testing.T, stub mockInvoker, args ...string) []byte {
+	t.Helper()
+	response := invoke(stub, args...)
+	if response.Status != shim.OK {
+		t.Fatalf("%v failed with status %d: %s", args, response.Status, response.Message)
+	}
+	return response.Payload
+}
+
+// seedWorkshop registers a supplier, the material types and the recipe of the std wand type, then
+// materials M1 to M5, wand W1 (red, size 10) made of M1 and M2, wand W2 (blue, size 12) made of M3 and M4,
+// and deletes M5
+func seedWorkshop(t *testing.T, stub mockInvoker) {
+	contactHash := sha256.Sum256([]byte("Diagon Alley"))
+	mustInvoke(t, stub, "registerSupplier", "S0", "Ollivander", ollivanderMSPID, hex.EncodeToString(contactHash[:]))
+	mustInvoke(t, stub, "addMaterialType", "holly", "Holly", "wood", "piece")
+	mustInvoke(t, stub, "addMaterialType", "oak", "Oak", "wood", "piece")
+	mustInvoke(t, stub, "addMaterialType", "phoenix", "Phoenix feather", "core", "unit")
+	mustInvoke(t, stub, "setRecipe", "std", "wood", "1", "core", "1")
+	mustInvoke(t, stub, "initMaterial", "M1", "holly", "S0")
+	mustInvoke(t, stub, "initMaterial", "M2", "phoenix", "S0")
+	mustInvoke(t, stub, "initMaterial", "M3", "oak", "S0")
+	mustInvoke(t, stub, "initMaterial", "M4", "phoenix", "S0")
+	mustInvoke(t, stub, "initMaterial", "M5", "holly", "S0")
+	mustInvoke(t, stub, "initWand", "W1", "std", "red", "10", "2", "M1", "M2")
+	mustInvoke(t, stub, "initWand", "W2", "std", "blue", "12", "2", "M3", "M4")
+	mustInvoke(t, stub, "deleteMaterial", "M5")
+}
+
+// documentIDs returns the IDs of the JSON documents
+func documentIDs(t *testing.T, documentsJSON []byte) []string {
+	t.Helper()
+	var documents []struct {
+		ID string `json:"ID"`
+	}
+	if err := json.Unmarshal(documentsJSON, &documents); err != nil {
+		t.Fatalf("invalid documents %s: %s", documentsJSON, err)
+	}
+	ids := []string{}
+	for _, document := range documents {
+		ids = append(ids, document.ID)
+	}
+	return ids
+}
+
+func TestRichQueryEvaluatesSelectorWithoutQueryEngine(t *testing.T) {
+	stub := newTestStub(t)
+	seedWorkshop(t, stub)
+
+	tests := []struct {
+		docType  string
+		selector string
+		want     []string
+	}{
+		{"Wand", `{"color":"red"}`, []string{"W1"}},
+		{"Wand", `{"size":{"$gt":11}}`, []string{"W2"}},
+		{"Wand", `{"$or":[{"color":"blue"},{"size":{"$lte":10}}]}`, []string{"W1", "W2"}},
+		{"Material", `{"type":{"$in":["holly","oak"]},"status":"consumed"}`, []string{"M1", "M3"}},
+		{"Material", `{"consumedBy":{"$ne":"W1"}}`, []string{"M3", "M4"}},
+		{"Material", `{"type":{"$nin":["phoenix"]}}`, []string{"M1", "M3"}}, // M5 is deleted
+		{"Material", `{}`, []string{"M1", "M2", "M3", "M4"}},
+	}
+	for _, test := range tests {
+		got := documentIDs(t, mustInvoke(t, stub, "richQuery", test.docType, test.selector))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("richQuery %s %s = %v, want %v", test.docType, test.selector, got, test.want)
+		}
+	}
+}
+
+func TestRichQueryRejectsSelectorsOutsideWhitelist(t *testing.T) {
+	stub := newTestStub(t)
+	seedWorkshop(t, stub)
+
+	tests := []struct {
+		docType  string
+		selector string
+		field    string
+	}{
+		{"Material", `{"price":1}`, "price"},
+		{"Material", `{"color":"red"}`, "color"},
+		{"Wand", `{"supplier":"S0"}`, "supplier"},
+		{"Material", `{"type":{"$regex":"^h"}}`, "type.$regex"},
+		{"Material", `{"$where":"true"}`, "$where"},
+		{"Material", `{"status":{}}`, "status"},
+		{"Wand", `{"$or":[{"color":"red"},{"deleted":{"$exists":true}}]}`, "$or[1].deleted"},
+		{"Wand", `{"$and":[]}`, "$and"},
+		{"Wand", `{"color":["red"]}`, "color"},
+		{"Wand", `[]`, ""},
+	}
+	for _, test := range tests {
+		response := invoke(stub, "richQuery", test.docType, test.selector)
+		if response.Status != UNPROCESSABLE {
+			t.Errorf("richQuery %s %s status = %d, want %d", test.docType, test.selector, response.Status, UNPROCESSABLE)
+			continue
+		}
+		var message struct {
+			Violations []FieldError `json:"violations"`
+		}
+		if err := json.Unmarshal([]byte(response.Message), &message); err != nil {
+			t.Fatalf("invalid message %s: %s", response.Message, err)
+		}
+		if len(message.Violations) != 1 || message.Violations[0].Field != test.field {
+			t.Errorf("richQuery %s %s violations = %v, want one on %q", test.docType, test.selector, message.Violations, test.field)
+		}
+	}
+
+	if response := invoke(stub, "richQuery", "Supplier", `{}`); response.Status != shim.ERROR {
+		t.Errorf("richQuery of suppliers status = %d, want %d", response.Status, shim.ERROR)
+	}
+}
+
+func TestRichQueryPagination(t *testing.T) {
+	stub := newTestStub(t)
+	seedWorkshop(t, stub)
+
+	var got []string
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("pagination did not end, got %v", got)
+		}
+		var page struct {
+			Records             json.RawMessage `json:"records"`
+			FetchedRecordsCount int32           `json:"fetchedRecordsCount"`
+			Bookmark            string          `json:"bookmark"`
+		}
+		pageJSON := mustInvoke(t, stub, "richQuery", "Material", `{"status":"consumed"}`, "3", bookmark)
+		if err := json.Unmarshal(pageJSON, &page); err != nil {
+			t.Fatalf("invalid page %s: %s", pageJSON, err)
+		}
+		ids := documentIDs(t, page.Records)
+		if int(page.FetchedRecordsCount) != len(ids) {
+			t.Errorf("fetchedRecordsCount = %d, want %d", page.FetchedRecordsCount, len(ids))
+		}
+		got = append(got, ids...)
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if want := []string{"M1", "M2", "M3", "M4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("paged richQuery = %v, want %v", got, want)
+	}
+}
+
+// historyStub is a mock stub that also implements what shimtest.MockStub leaves out: the history of the keys,
+// the hashes of the private data and the paginated queries on composite keys. Every transaction is one
+// second after the previous one, so the history is ordered by timestamp.
+type historyStub struct {
+	*shimtest.MockStub
+	args     [][]byte
+	history  map[string][]*queryresult.KeyModification // newest first, as the peers return it
+	txNumber int64
+}
+
+func newHistoryStub(t *testing.T) *historyStub {
+	return &historyStub{MockStub: newTestStub(t), history: make(map[string][]*queryresult.KeyModification)}
+}
+
+func (stub *historyStub) MockTransactionStart(txID string) {
+	stub.MockStub.MockTransactionStart(txID)
+	stub.txNumber++
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: 1700000000 + stub.txNumber}
+}
+
+func (stub *historyStub) MockInit(uuid string, args [][]byte) pb.Response {
+	stub.args = args
+	stub.MockTransactionStart(uuid)
+	defer stub.MockTransactionEnd(uuid)
+	return new(Studio).Init(stub)
+}
+
+func (stub *historyStub) MockInvoke(uuid string, args [][]byte) pb.Response {
+	stub.args = args
+	stub.MockTransactionStart(uuid)
+	defer stub.MockTransactionEnd(uuid)
+	return new(Studio).Invoke(stub)
+}
+
+func (stub *historyStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *historyStub) GetStringArgs() []string {
+	var args []string
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *historyStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+// record saves a modification of the key in its history
+func (stub *historyStub) record(key string, value []byte, isDelete bool) {
+	modification := &queryresult.KeyModification{TxId: stub.TxID, Value: value, Timestamp: stub.TxTimestamp, IsDelete: isDelete}
+	stub.history[key] = append([]*queryresult.KeyModification{modification}, stub.history[key]...)
+}
+
+func (stub *historyStub) PutState(key string, value []byte) error {
+	stub.record(key, value, false)
+	return stub.MockStub.PutState(key, value)
+}
+
+func (stub *historyStub) DelState(key string) error {
+	stub.record(key, nil, true)
+	return stub.MockStub.DelState(key)
+}
+
+func (stub *historyStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: stub.history[key]}, nil
+}
+
+func (stub *historyStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	value, err := stub.GetPrivateData(collection, key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+func (stub *historyStub) DelPrivateData(collection, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns the keys from the bookmark on, the bookmark being the first key of the page
+func (stub *historyStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &pageIterator{}
+	responseMetadata := &pb.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		result, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if result.Key < bookmark {
+			continue
+		}
+		if len(page.results) == int(pageSize) {
+			responseMetadata.Bookmark = result.Key
+			break
+		}
+		page.results = append(page.results, result)
+	}
+	responseMetadata.FetchedRecordsCount = int32(len(page.results))
+	return page, responseMetadata, nil
+}
+
+// historyIterator iterates over the modifications of a key
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (iterator *historyIterator) HasNext() bool { return len(iterator.modifications) > 0 }
+func (iterator *historyIterator) Close() error  { return nil }
+func (iterator *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if len(iterator.modifications) == 0 {
+		return nil, errors.New("no more modifications")
+	}
+	modification := iterator.modifications[0]
+	iterator.modifications = iterator.modifications[1:]
+	return modification, nil
+}
+
+// pageIterator iterates over the results of one page
+type pageIterator struct {
+	results []*queryresult.KV
+}
+
+func (iterator *pageIterator) HasNext() bool { return len(iterator.results) > 0 }
+func (iterator *pageIterator) Close() error  { return nil }
+func (iterator *pageIterator) Next() (*queryresult.KV, error) {
+	if len(iterator.results) == 0 {
+		return nil, errors.New("no more results")
+	}
+	result := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return result, nil
+}
+
+// queryErrorStub is a stub whose state database fails rich queries with the given error
+type queryErrorStub struct {
+	*shimtest.MockStub
+	err error
+}
+
+func (stub *queryErrorStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, stub.err
+}
+
+func (stub *queryErrorStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, stub.err
+}
+
+func TestRichQueryOnlyFallsBackWithoutQueryEngine(t *testing.T) {
+	mockStub := newTestStub(t)
+	seedWorkshop(t, mockStub)
+
+	tests := []struct {
+		err      error
+		fallback bool
+	}{
+		{errors.New("ExecuteQuery not supported for leveldb"), true},
+		{errors.New("ExecuteQueryWithMetadata not supported for leveldb"), true},
+		{errors.New("invalid bookmark value"), false},
+		{errors.New("timeout expired while executing transaction"), false},
+	}
+	for _, test := range tests {
+		stub := &queryErrorStub{MockStub: mockStub, err: test.err}
+		for _, args := range [][]string{{"Wand", `{"color":"red"}`}, {"Wand", `{"color":"red"}`, "1", "g1AAAA"}} {
+			mockStub.MockTransactionStart("query")
+			response := new(Studio).richQuery(stub, args)
+			mockStub.MockTransactionEnd("query")
+			if fallback := response.Status == shim.OK; fallback != test.fallback {
+				t.Errorf("richQuery %v with error %q: status %d %s, fallback = %v, want %v",
+					args, test.err, response.Status, response.Message, fallback, test.fallback)
+			}
+		}
+	}
+}
+
+// transactionStub is a stub the tests write to in their own mocked transactions
+type transactionStub interface {
+	shim.ChaincodeStubInterface
+	MockTransactionStart(txID string)
+	MockTransactionEnd(txID string)
+}
+
+// seedBaselineLedger writes a ledger as the first version of the chaincode left it: documents under their
+// raw ID, type~ID keys for every material, and the materialIndexList and wandsIndexList JSON lists.
+// Wand W1 consumed M1 and M2, which the old initWand removed from materialIndexList but left in type~ID.
+func seedBaselineLedger(t *testing.T, stub transactionStub) {
+	stub.MockTransactionStart("baseline")
+	defer stub.MockTransactionEnd("baseline")
+
+	putJSON := func(key string, value interface{}) {
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stub.PutState(key, valueJSON); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compositeKey := func(objectType string, attributes ...string) string {
+		key, err := stub.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	materials := []map[string]string{
+		{"docType": "Material", "ID": "M1", "type": "holly", "supplier": "Hagrid"},
+		{"docType": "Material", "ID": "M2", "type": "phoenix", "supplier": "Hagrid"},
+		{"docType": "Material", "ID": "M3", "type": "holly", "supplier": "hagrid"},
+	}
+	for _, material := range materials {
+		putJSON(material["ID"], material)
+		if err := stub.PutState(compositeKey(legacyTypeIndex, material["type"], material["ID"]), []byte{0x00}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putJSON("W1", map[string]interface{}{"docType": "Wand", "ID": "W1", "type": "std", "color": "red", "size": 10, "Materials": []string{"M1", "M2"}})
+	putJSON(legacyMaterialIndexListKey, []string{compositeKey(legacyTypeIndex, "holly", "M3")})
+	putJSON(legacyWandsIndexListKey, []string{compositeKey(legacyTypeIndex, "std", "W1")})
+}
+
+// copyState returns a copy of the world state of the mock stub
+func copyState(stub *shimtest.MockStub) map[string]string {
+	state := make(map[string]string, len(stub.State))
+	for key, value := range stub.State {
+		state[key] = string(value)
+	}
+	return state
+}
+
+func TestInitMigratesBaselineLedger(t *testing.T) {
+	stub := newTestStub(t)
+	seedBaselineLedger(t, stub)
+
+	if response := stub.MockInit("init", nil); response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+
+	// ==== Legacy keys are gone ====
+	for _, key := range []string{"M1", "M2", "M3", "W1", legacyMaterialIndexListKey, legacyWandsIndexListKey} {
+		if value, _ := stub.GetState(key); value != nil {
+			t.Errorf("legacy key %s is still in state", key)
+		}
+	}
+	stub.MockTransactionStart("keys")
+	legacyTypeKeys, err := getIndexKeys(stub, legacyTypeIndex, []string{})
+	stub.MockTransactionEnd("keys")
+	if err != nil || len(legacyTypeKeys) > 0 {
+		t.Errorf("legacy type~ID keys = %q, %v, want none", legacyTypeKeys, err)
+	}
+
+	// ==== Statuses are inferred from the lists ====
+	tests := []struct {
+		ID         string
+		status     string
+		consumedBy string
+	}{
+		{"M1", statusConsumed, "W1"},
+		{"M2", statusConsumed, "W1"},
+		{"M3", statusAvailable, ""},
+	}
+	for _, test := range tests {
+		var material Material
+		if err := json.Unmarshal(mustInvoke(t, stub, "readMaterial", test.ID), &material); err != nil {
+			t.Fatal(err)
+		}
+		if material.Status != test.status || material.ConsumedBy != test.consumedBy {
+			t.Errorf("material %s status = %q consumed by %q, want %q consumed by %q",
+				test.ID, material.Status, material.ConsumedBy, test.status, test.consumedBy)
+		}
+		// Migrated documents were not created by the migration
+		if material.CreatedAt != "" || material.CreatedTxID != "" || material.CreatedBy != nil {
+			t.Errorf("material %s created metadata = %+v, want none", test.ID, material.Metadata)
+		}
+	}
+	var migratedWand Wand
+	if err := json.Unmarshal(mustInvoke(t, stub, "readWand", "W1"), &migratedWand); err != nil {
+		t.Fatal(err)
+	}
+	if migratedWand.Status != wandStatusInStock || migratedWand.CreatedAt != "" {
+		t.Errorf("wand W1 status = %q created at %q, want %q and no creation time", migratedWand.Status, migratedWand.CreatedAt, wandStatusInStock)
+	}
+
+	// ==== The new indexes answer the list queries ====
+	if got, want := documentIDs(t, mustInvoke(t, stub, "getMaterialsByType", "holly")), []string{"M3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getMaterialsByType holly = %v, want %v", got, want)
+	}
+	if got, want := documentIDs(t, mustInvoke(t, stub, "getWandsByType", "std")), []string{"W1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getWandsByType std = %v, want %v", got, want)
+	}
+	var wand Wand
+	if err := json.Unmarshal(mustInvoke(t, stub, "getWandByMaterial", "M2"), &wand); err != nil || wand.ID != "W1" {
+		t.Errorf("getWandByMaterial M2 = %s, %v, want W1", wand.ID, err)
+	}
+
+	// ==== A second Init finds nothing to migrate ====
+	migratedState := copyState(stub)
+	if response := stub.MockInit("init-again", nil); response.Status != shim.OK {
+		t.Fatalf("second Init failed: %s", response.Message)
+	}
+	if state := copyState(stub); !reflect.DeepEqual(state, migratedState) {
+		t.Errorf("second Init changed the state")
+	}
+
+	// ==== The migrated ledger is consistent ====
+	var report IntegrityReport
+	if err := json.Unmarshal(mustInvoke(t, stub, "checkIntegrity"), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Materials != 3 || report.Wands != 1 {
+		t.Errorf("checkIntegrity checked %d materials and %d wands, want 3 and 1", report.Materials, report.Wands)
+	}
+	if len(report.Orphans)+len(report.Duplicates)+len(report.Missing)+len(report.DanglingReferences)+len(report.LegacyKeys) > 0 {
+		t.Errorf("checkIntegrity found problems after the migration: %+v", report)
+	}
+}
+
+// traceMaterial returns the ID of the wand getWandByMaterial traces the material to
+func traceMaterial(t *testing.T, stub mockInvoker, materialID string) string {
+	t.Helper()
+	var wand Wand
+	if err := json.Unmarshal(mustInvoke(t, stub, "getWandByMaterial", materialID), &wand); err != nil {
+		t.Fatal(err)
+	}
+	return wand.ID
+}
+
+func TestRecallTracesDismantledWands(t *testing.T) {
+	stub := newTestStub(t)
+	seedWorkshop(t, stub)
+
+	mustInvoke(t, stub, "dismantleWand", "W2", "M4")
+	if got := traceMaterial(t, stub, "M3"); got != "W2" {
+		t.Errorf("released M3 traced to %q, want W2", got)
+	}
+	if got := traceMaterial(t, stub, "M4"); got != "W2" {
+		t.Errorf("destroyed M4 traced to %q, want W2", got)
+	}
+
+	// The released material is consumed again, it is traced to the new wand and the supplier to both
+	mustInvoke(t, stub, "initMaterial", "M6", "phoenix", "S0")
+	mustInvoke(t, stub, "initWand", "W3", "std", "green", "11", "2", "M3", "M6")
+	if got := traceMaterial(t, stub, "M3"); got != "W3" {
+		t.Errorf("M3 traced to %q after joining W3, want W3", got)
+	}
+	if got, want := documentIDs(t, mustInvoke(t, stub, "getWandsBySupplier", "S0")), []string{"W1", "W2", "W3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getWandsBySupplier S0 = %v, want %v", got, want)
+	}
+
+	var report IntegrityReport
+	if err := json.Unmarshal(mustInvoke(t, stub, "checkIntegrity"), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans)+len(report.Duplicates)+len(report.Missing)+len(report.DanglingReferences) > 0 {
+		t.Errorf("checkIntegrity found problems after the dismantling: %+v", report)
+	}
+}
+
+func TestRecallTracesRepairedWands(t *testing.T) {
+	stub := newTestStub(t)
+	seedWorkshop(t, stub)
+
+	mustInvoke(t, stub, "initMaterial", "M6", "phoenix", "S0")
+	mustInvoke(t, stub, "repairWand", "W1", "cracked core", "M2", "M6")
+	if got := traceMaterial(t, stub, "M2"); got != "W1" {
+		t.Errorf("removed M2 traced to %q, want W1", got)
+	}
+	if got := traceMaterial(t, stub, "M6"); got != "W1" {
+		t.Errorf("installed M6 traced to %q, want W1", got)
+	}
+	if got, want := documentIDs(t, mustInvoke(t, stub, "getWandsBySupplier", "S0")), []string{"W1", "W2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getWandsBySupplier S0 = %v, want %v", got, want)
+	}
+
+	var report IntegrityReport
+	if err := json.Unmarshal(mustInvoke(t, stub, "checkIntegrity"), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans)+len(report.Duplicates)+len(report.Missing)+len(report.DanglingReferences) > 0 {
+		t.Errorf("checkIntegrity found problems after the repair: %+v", report)
+	}
+}
+
+func TestWandTypesWithoutRecipeAcceptAnyMaterials(t *testing.T) {
+	stub := newTestStub(t)
+	seedWorkshop(t, stub)
+
+	mustInvoke(t, stub, "initMaterial", "M6", "holly", "S0")
+	mustInvoke(t, stub, "initMaterial", "M7", "oak", "S0")
+	mustInvoke(t, stub, "initWand", "W3", "custom", "black", "9", "2", "M6", "M7")
+	mustInvoke(t, stub, "initMaterial", "M8", "phoenix", "S0")
+	mustInvoke(t, stub, "repairWand", "W3", "split shaft", "M7", "M8")
+
+	// Once the type has a recipe, its wands must follow it
+	mustInvoke(t, stub, "setRecipe", "custom", "wood", "2")
+	mustInvoke(t, stub, "initMaterial", "M9", "oak", "S0")
+	if response := invoke(stub, "repairWand", "W3", "split shaft", "M6", "M9"); response.Status != 422 {
+		t.Errorf("repairWand against the new recipe returned status %d, want 422: %s", response.Status, response.Message)
+	}
+}
+
+func TestUpdateMaterialChecksTheRecipeOfTheConsumingWand(t *testing.T) {
+	stub := newTestStub(t)
+	seedWorkshop(t, stub)
+
+	var material Material
+	if err := json.Unmarshal(mustInvoke(t, stub, "readMaterial", "M2"), &material); err != nil {
+		t.Fatal(err)
+	}
+	version := fmt.Sprint(material.Version)
+	if response := invoke(stub, "updateMaterial", "M2", `{"type":"oak"}`, version); response.Status != 422 {
+		t.Errorf("updateMaterial moving the core of W1 to wood returned status %d, want 422: %s", response.Status, response.Message)
+	}
+
+	// Another type of the same category keeps the wand following the recipe
+	mustInvoke(t, stub, "addMaterialType", "unicorn", "Unicorn hair", "core", "unit")
+	mustInvoke(t, stub, "updateMaterial", "M2", `{"type":"unicorn"}`, version)
+}
+
+func TestWandProvenanceTransactions(t *testing.T) {
+	stub := newHistoryStub(t)
+	seedWorkshop(t, stub)
+
+	mustInvoke(t, stub, "initMaterial", "M6", "phoenix", "S0")
+	mustInvoke(t, stub, "repairWand", "W1", "cracked core", "M2", "M6")
+	var wand Wand
+	if err := json.Unmarshal(mustInvoke(t, stub, "readWand", "W1"), &wand); err != nil {
+		t.Fatal(err)
+	}
+	// Later saves of the material do not move its registration
+	var material Material
+	if err := json.Unmarshal(mustInvoke(t, stub, "readMaterial", "M1"), &material); err != nil {
+		t.Fatal(err)
+	}
+	mustInvoke(t, stub, "updateMaterial", "M1", `{"attributes":{"origin":"Hogwarts"}}`, fmt.Sprint(material.Version))
+
+	var provenance WandProvenance
+	if err := json.Unmarshal(mustInvoke(t, stub, "getWandProvenance", "W1"), &provenance); err != nil {
+		t.Fatal(err)
+	}
+	materials := append(provenance.Materials, provenance.RemovedMaterials...)
+	if len(materials) != 3 {
+		t.Fatalf("provenance lists %d materials, want M1, M6 and the removed M2", len(materials))
+	}
+	repairTxID := wand.Repairs[0].TxID
+	for _, materialProvenance := range materials {
+		material := materialProvenance.Material
+		consumedTxID := wand.CreatedTxID
+		if material.ID == "M6" {
+			consumedTxID = repairTxID
+		}
+		if materialProvenance.RegisteredTxID != material.CreatedTxID || materialProvenance.RegisteredAt != material.CreatedAt {
+			t.Errorf("material %s registered in %s at %s, want %s at %s", material.ID,
+				materialProvenance.RegisteredTxID, materialProvenance.RegisteredAt, material.CreatedTxID, material.CreatedAt)
+		}
+		if materialProvenance.ConsumedTxID != consumedTxID {
+			t.Errorf("material %s consumed in %s, want %s", material.ID, materialProvenance.ConsumedTxID, consumedTxID)
+		}
+	}
+}
+
+func TestWandProvenanceOfMigratedLedger(t *testing.T) {
+	stub := newHistoryStub(t)
+	seedBaselineLedger(t, stub)
+	if response := stub.MockInit("migration", nil); response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+
+	var provenance WandProvenance
+	if err := json.Unmarshal(mustInvoke(t, stub, "getWandProvenance", "W1"), &provenance); err != nil {
+		t.Fatal(err)
+	}
+	if len(provenance.Materials) != 2 {
+		t.Fatalf("provenance lists %d materials, want M1 and M2", len(provenance.Materials))
+	}
+	for _, materialProvenance := range provenance.Materials {
+		// The registration is found under the raw key, the consumption was not recorded by the first version
+		if materialProvenance.RegisteredTxID != "baseline" || materialProvenance.RegisteredAt == "" {
+			t.Errorf("material %s registered in %q at %q, want the baseline transaction",
+				materialProvenance.Material.ID, materialProvenance.RegisteredTxID, materialProvenance.RegisteredAt)
+		}
+		if materialProvenance.ConsumedTxID != "" || materialProvenance.ConsumedAt != "" {
+			t.Errorf("material %s consumed in %q at %q, want none", materialProvenance.Material.ID,
+				materialProvenance.ConsumedTxID, materialProvenance.ConsumedAt)
+		}
+	}
+}
+
+// historyOf returns the transaction IDs and delete flags of the history returned by the function
+func historyOf(t *testing.T, stub mockInvoker, function string, ID string) ([]string, []bool) {
+	t.Helper()
+	var history []HistoryEntry
+	if err := json.Unmarshal(mustInvoke(t, stub, function, ID), &history); err != nil {
+		t.Fatal(err)
+	}
+	var txIDs []string
+	var deletes []bool
+	for i, entry := range history {
+		if i > 0 && historyTime(entry).Before(historyTime(history[i-1])) {
+			t.Errorf("%s %s: entry %d at %s is older than the previous one", function, ID, i, entry.Timestamp)
+		}
+		if entry.IsDelete != (entry.Value == nil) {
+			t.Errorf("%s %s: entry %d has delete flag %v and value %s", function, ID, i, entry.IsDelete, entry.Value)
+		}
+		txIDs = append(txIDs, entry.TxID)
+		deletes = append(deletes, entry.IsDelete)
+	}
+	return txIDs, deletes
+}
+
+func TestDocumentHistory(t *testing.T) {
+	stub := newHistoryStub(t)
+	seedWorkshop(t, stub)
+
+	// M5 was registered and deleted by seedWorkshop, it is purged and registered again
+	var material Material
+	if err := json.Unmarshal(mustInvoke(t, stub, "readMaterial", "M5"), &material); err != nil {
+		t.Fatal(err)
+	}
+	mustInvoke(t, stub, "purgeMaterial", "M5")
+	purgeTxID := lastTxID()
+	mustInvoke(t, stub, "initMaterial", "M5", "oak", "S0")
+	registerTxID := lastTxID()
+
+	txIDs, deletes := historyOf(t, stub, "getMaterialHistory", "M5")
+	wantTxIDs := []string{material.CreatedTxID, material.Deleted.TxID, purgeTxID, registerTxID}
+	if !reflect.DeepEqual(txIDs, wantTxIDs) || !reflect.DeepEqual(deletes, []bool{false, false, true, false}) {
+		t.Errorf("getMaterialHistory M5 = %v with deletes %v, want %v with only the purge deleting", txIDs, deletes, wantTxIDs)
+	}
+
+	if txIDs, deletes = historyOf(t, stub, "getWandHistory", "W9"); len(txIDs) != 0 {
+		t.Errorf("getWandHistory of a missing wand = %v %v, want none", txIDs, deletes)
+	}
+}
+
+func TestDocumentHistoryOfMigratedLedger(t *testing.T) {
+	stub := newHistoryStub(t)
+	seedBaselineLedger(t, stub)
+	if response := stub.MockInit("migration", nil); response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+	mustInvoke(t, stub, "dismantleWand", "W1")
+	dismantleTxID := lastTxID()
+
+	// The raw key was written by the first version and deleted by the migration, which saved the namespaced key
+	tests := []struct {
+		function string
+		ID       string
+		txIDs    []string
+		deletes  []bool
+	}{
+		{"getMaterialHistory", "M1", []string{"baseline", "migration", "migration", dismantleTxID}, []bool{false, true, false, false}},
+		{"getMaterialHistory", "M3", []string{"baseline", "migration", "migration"}, []bool{false, true, false}},
+		{"getWandHistory", "W1", []string{"baseline", "migration", "migration", dismantleTxID}, []bool{false, true, false, false}},
+	}
+	for _, test := range tests {
+		txIDs, deletes := historyOf(t, stub, test.function, test.ID)
+		if !reflect.DeepEqual(txIDs, test.txIDs) || !reflect.DeepEqual(deletes, test.deletes) {
+			t.Errorf("%s %s = %v with deletes %v, want %v with deletes %v", test.function, test.ID, txIDs, deletes, test.txIDs, test.deletes)
+		}
+	}
+}
+
+func TestMaterialPrivateDetails(t *testing.T) {
+	stub := newHistoryStub(t)
+	seedWorkshop(t, stub)
+	t.Setenv("CORE_PEER_LOCALMSPID", ollivanderMSPID)
+
+	privateDetailsJSON := `{"unitPrice":120,"contractRef":"C-7","batchCode":"B-1"}`
+	stub.TransientMap = map[string][]byte{materialPrivateDetailsTransientKey: []byte(privateDetailsJSON)}
+	mustInvoke(t, stub, "initMaterial", "M6", "phoenix", "S0")
+
+	var privateDetails MaterialPrivateDetails
+	if err := json.Unmarshal(mustInvoke(t, stub, "readMaterialPrivateDetails", "M6"), &privateDetails); err != nil {
+		t.Fatal(err)
+	}
+	want := MaterialPrivateDetails{ObjectType: materialPrivateDetailsObjectType, ID: "M6", UnitPrice: 120, ContractRef: "C-7", BatchCode: "B-1"}
+	if privateDetails != want {
+		t.Errorf("readMaterialPrivateDetails M6 = %+v, want %+v", privateDetails, want)
+	}
+
+	tests := []struct {
+		privateDetailsJSON string
+		verified           bool
+	}{
+		{privateDetailsJSON, true},
+		{`{"unitPrice":99,"contractRef":"C-7","batchCode":"B-1"}`, false},
+	}
+	for _, test := range tests {
+		stub.TransientMap = map[string][]byte{materialPrivateDetailsTransientKey: []byte(test.privateDetailsJSON)}
+		var result struct {
+			Verified    bool            `json:"verified"`
+			Collections map[string]bool `json:"collections"`
+		}
+		if err := json.Unmarshal(mustInvoke(t, stub, "verifyMaterialPrivateDetails", "M6"), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Verified != test.verified || len(result.Collections) == 0 {
+			t.Errorf("verifyMaterialPrivateDetails %s = %+v, want verified %v", test.privateDetailsJSON, result, test.verified)
+		}
+		for collection, verified := range result.Collections {
+			if verified != test.verified {
+				t.Errorf("verifyMaterialPrivateDetails %s in %s = %v, want %v", test.privateDetailsJSON, collection, verified, test.verified)
+			}
+		}
+	}
+}
+
+// page is a page returned by the paginated queries, with the IDs of its records
+type page struct {
+	IDs                 []string
+	FetchedRecordsCount int32
+	Bookmark            string
+}
+
+// readPage calls the paginated query with the page size and bookmark and returns the page
+func readPage(t *testing.T, stub mockInvoker, args []string, pageSize int, bookmark string) page {
+	t.Helper()
+	var response struct {
+		Records             json.RawMessage `json:"records"`
+		FetchedRecordsCount int32           `json:"fetchedRecordsCount"`
+		Bookmark            string          `json:"bookmark"`
+	}
+	args = append(append([]string{}, args...), fmt.Sprint(pageSize), bookmark)
+	if err := json.Unmarshal(mustInvoke(t, stub, args...), &response); err != nil {
+		t.Fatal(err)
+	}
+	return page{IDs: documentIDs(t, response.Records), FetchedRecordsCount: response.FetchedRecordsCount, Bookmark: response.Bookmark}
+}
+
+func TestPaginatedLists(t *testing.T) {
+	stub := newHistoryStub(t)
+	seedWorkshop(t, stub)
+
+	tests := []struct {
+		args     []string
+		pageSize int
+		pages    [][]string
+	}{
+		{[]string{"getMaterialsByStatus", statusConsumed}, 3, [][]string{{"M1", "M2", "M3"}, {"M4"}}},
+		{[]string{"getAllWands"}, 1, [][]string{{"W1"}, {"W2"}}},
+		{[]string{"getWandsByType", "std"}, 1, [][]string{{"W1"}, {"W2"}}},
+	}
+	for _, test := range tests {
+		bookmark := ""
+		for i, want := range test.pages {
+			got := readPage(t, stub, test.args, test.pageSize, bookmark)
+			if !reflect.DeepEqual(got.IDs, want) || got.FetchedRecordsCount != int32(len(want)) {
+				t.Errorf("%v page %d = %v with count %d, want %v", test.args, i, got.IDs, got.FetchedRecordsCount, want)
+			}
+			if last := i == len(test.pages)-1; last != (got.Bookmark == "") {
+				t.Errorf("%v page %d bookmark = %q, want it empty only on the last page", test.args, i, got.Bookmark)
+			}
+			bookmark = got.Bookmark
+		}
+	}
+}
+
+func TestQueryMaterialsPagination(t *testing.T) {
+	stub := newHistoryStub(t)
+	seedWorkshop(t, stub)
+
+	tests := []struct {
+		filter   string
+		pageSize int
+		pages    [][]string
+	}{
+		{`{"supplier":"S0","sort":"createdAt"}`, 3, [][]string{{"M1", "M2", "M3"}, {"M4"}}},
+		{`{"type":"phoenix","sort":"createdAt"}`, 1, [][]string{{"M2"}, {"M4"}}},
+		{`{"status":"consumed"}`, 2, [][]string{{"M1", "M2"}, {"M3", "M4"}}},
+	}
+	for _, test := range tests {
+		args := []string{"queryMaterials", test.filter}
+		var got [][]string
+		bookmark := ""
+		for len(got) <= len(test.pages) {
+			page := readPage(t, stub, args, test.pageSize, bookmark)
+			if len(page.IDs) > 0 {
+				got = append(got, page.IDs)
+			}
+			if bookmark = page.Bookmark; bookmark == "" {
+				break
+			}
+		}
+		if !reflect.DeepEqual(got, test.pages) {
+			t.Errorf("queryMaterials %s pages = %v, want %v", test.filter, got, test.pages)
+		}
+	}
+
+	// The month indexes are not sorted by ID, their order must be asked for
+	for _, filter := range []string{`{"supplier":"S0"}`, `{"supplier":"S0","sort":"ID"}`, `{"supplier":"S0","sort":"createdAt","order":"desc"}`} {
+		if response := invoke(stub, "queryMaterials", filter, "2", ""); response.Status != 422 {
+			t.Errorf("paged queryMaterials %s returned status %d, want 422: %s", filter, response.Status, response.Message)
+		}
+	}
+}